@@ -0,0 +1,367 @@
+package dbs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	auditStateKey = "dbs:audit:state"
+)
+
+var (
+	ErrVersionConflict = errors.New("optimistic lock version conflict")
+
+	auditableType = reflect.TypeOf((*auditable)(nil)).Elem()
+)
+
+type actorCtxKey struct{}
+
+// WithActor 把当前操作人放到 ctx 中，审计插件用它填充 created_by/updated_by
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+// ActorFromContext 取出当前操作人
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorCtxKey{}).(string)
+	return actor
+}
+
+type auditable interface {
+	auditModel() *AuditModel
+}
+
+// AuditModel 嵌入到模型中开启审计：
+// 创建时填充 created_by/updated_by，更新时填充 updated_by 并校验 version 乐观锁，
+// 更新和删除时把每行变化的字段写到审计表
+type AuditModel struct {
+	CreatedBy string `gorm:"column:created_by;size:64" json:"createdBy"`
+	UpdatedBy string `gorm:"column:updated_by;size:64" json:"updatedBy"`
+	Version   int64  `gorm:"column:version;not null;default:1" json:"version"`
+}
+
+func (sel *AuditModel) auditModel() *AuditModel {
+	return sel
+}
+
+// AuditLog 审计记录，Diff 为 {"column": [old, new]}，删除时 new 为 null
+type AuditLog struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	Table     string    `gorm:"column:table_name;size:64;not null;index:idx_audit_row"`
+	RowID     string    `gorm:"column:row_id;size:128;not null;index:idx_audit_row"`
+	Action    string    `gorm:"column:action;size:16;not null"`
+	Actor     string    `gorm:"column:actor;size:64"`
+	Diff      string    `gorm:"column:diff;type:json"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+type AuditOption func(p *AuditPlugin)
+
+// WithAuditTable 审计表名，默认 audit_log
+func WithAuditTable(table string) AuditOption {
+	return func(p *AuditPlugin) {
+		p.table = table
+	}
+}
+
+// AuditPlugin gorm 审计插件
+//
+//	db.Use(dbs.NewAuditPlugin())
+//	db.WithContext(dbs.WithActor(ctx, "alice")).Save(&order)
+type AuditPlugin struct {
+	table string
+}
+
+func NewAuditPlugin(opts ...AuditOption) *AuditPlugin {
+	p := &AuditPlugin{table: "audit_log"}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *AuditPlugin) Name() string {
+	return "dbs:audit"
+}
+
+func (p *AuditPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register(p.Name()+":create", p.beforeCreate); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register(p.Name()+":before_update", p.beforeUpdate); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register(p.Name()+":after_update", p.afterUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register(p.Name()+":before_delete", p.beforeDelete); err != nil {
+		return err
+	}
+	return cb.Delete().After("gorm:delete").Register(p.Name()+":after_delete", p.afterDelete)
+}
+
+// CreateTable 创建审计表
+func (p *AuditPlugin) CreateTable(db *gorm.DB) error {
+	return db.Table(p.table).AutoMigrate(&AuditLog{})
+}
+
+// auditState 在 before/after 回调之间传递的状态
+type auditState struct {
+	version    int64 // 校验的版本号，0 表示没有校验
+	oldRows    []map[string]interface{}
+	versionSet bool
+}
+
+func isAuditable(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil &&
+		reflect.PointerTo(db.Statement.Schema.ModelType).Implements(auditableType)
+}
+
+func (p *AuditPlugin) beforeCreate(db *gorm.DB) {
+	if !isAuditable(db) {
+		return
+	}
+	stmt := db.Statement
+	actor := ActorFromContext(stmt.Context)
+	eachModel(stmt.ReflectValue, func(rv reflect.Value) {
+		m := rv.Addr().Interface().(auditable).auditModel()
+		if m.CreatedBy == "" {
+			m.CreatedBy = actor
+		}
+		if m.UpdatedBy == "" {
+			m.UpdatedBy = actor
+		}
+		if m.Version == 0 {
+			m.Version = 1
+		}
+	})
+}
+
+func eachModel(rv reflect.Value, fn func(rv reflect.Value)) {
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			eachModel(rv.Index(i), fn)
+		}
+	case reflect.Struct:
+		if rv.CanAddr() {
+			fn(rv)
+		}
+	}
+}
+
+func (p *AuditPlugin) beforeUpdate(db *gorm.DB) {
+	if !isAuditable(db) {
+		return
+	}
+	stmt := db.Statement
+	state := &auditState{}
+	if actor := ActorFromContext(stmt.Context); actor != "" {
+		stmt.SetColumn("updated_by", actor, true)
+	}
+	if field := stmt.Schema.LookUpField("version"); field != nil {
+		if stmt.ReflectValue.Kind() == reflect.Struct {
+			if v, zero := field.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+				state.version = v.(int64)
+			}
+		}
+		if state.version > 0 {
+			stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "version"}, Value: state.version},
+			}})
+			stmt.SetColumn("version", state.version+1, true)
+			state.versionSet = true
+		} else if _, ok := stmt.Dest.(map[string]interface{}); ok {
+			stmt.SetColumn("version", gorm.Expr("`version` + 1"), true)
+		}
+	}
+	state.oldRows = p.loadRows(db)
+	db.InstanceSet(auditStateKey, state)
+}
+
+func (p *AuditPlugin) afterUpdate(db *gorm.DB) {
+	v, ok := db.InstanceGet(auditStateKey)
+	if !ok || db.Error != nil {
+		return
+	}
+	state := v.(*auditState)
+	stmt := db.Statement
+	if state.versionSet && db.RowsAffected == 0 && !db.DryRun {
+		if field := stmt.Schema.LookUpField("version"); field != nil && stmt.ReflectValue.Kind() == reflect.Struct {
+			_ = field.Set(stmt.Context, stmt.ReflectValue, state.version)
+		}
+		_ = db.AddError(ErrVersionConflict)
+		return
+	}
+	if len(state.oldRows) == 0 {
+		return
+	}
+	newRows := p.loadRowsByPK(db, state.oldRows)
+	logs := make([]AuditLog, 0, len(state.oldRows))
+	for _, old := range state.oldRows {
+		id := rowID(stmt.Schema.PrimaryFieldDBNames, old)
+		diff := diffRow(old, newRows[id])
+		if len(diff) == 0 {
+			continue
+		}
+		logs = append(logs, p.newLog(stmt, AuditActionUpdate, id, diff))
+	}
+	p.writeLogs(db, logs)
+}
+
+func (p *AuditPlugin) beforeDelete(db *gorm.DB) {
+	if !isAuditable(db) {
+		return
+	}
+	db.InstanceSet(auditStateKey, &auditState{oldRows: p.loadRows(db)})
+}
+
+func (p *AuditPlugin) afterDelete(db *gorm.DB) {
+	v, ok := db.InstanceGet(auditStateKey)
+	if !ok || db.Error != nil || db.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	state := v.(*auditState)
+	logs := make([]AuditLog, 0, len(state.oldRows))
+	for _, old := range state.oldRows {
+		id := rowID(stmt.Schema.PrimaryFieldDBNames, old)
+		logs = append(logs, p.newLog(stmt, AuditActionDelete, id, diffRow(old, nil)))
+	}
+	p.writeLogs(db, logs)
+}
+
+// session 在同一个连接（事务）上执行，强制走主库
+func auditSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, Context: WithPrimary(db.Statement.Context)})
+}
+
+// loadRows 按本次语句的条件和模型主键查出变更前的行，没有任何条件时不查询
+func (p *AuditPlugin) loadRows(db *gorm.DB) []map[string]interface{} {
+	stmt := db.Statement
+	if len(stmt.Schema.PrimaryFieldDBNames) == 0 {
+		return nil
+	}
+	query := auditSession(db).Table(stmt.Table)
+	hasCond := false
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+			query = query.Clauses(clause.Where{Exprs: where.Exprs})
+			hasCond = true
+		}
+	}
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, f := range stmt.Schema.PrimaryFields {
+			if v, zero := f.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+				query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: v})
+				hasCond = true
+			}
+		}
+	}
+	if !hasCond {
+		return nil
+	}
+	var rows []map[string]interface{}
+	if err := query.Find(&rows).Error; err != nil {
+		_ = db.AddError(fmt.Errorf("audit: load rows: %w", err))
+		return nil
+	}
+	return rows
+}
+
+func (p *AuditPlugin) loadRowsByPK(db *gorm.DB, oldRows []map[string]interface{}) map[string]map[string]interface{} {
+	stmt := db.Statement
+	pks := stmt.Schema.PrimaryFieldDBNames
+	var (
+		exprs []clause.Expression
+		rows  []map[string]interface{}
+	)
+	for _, old := range oldRows {
+		var ands []clause.Expression
+		for _, pk := range pks {
+			ands = append(ands, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: pk}, Value: old[pk]})
+		}
+		exprs = append(exprs, clause.And(ands...))
+	}
+	if err := auditSession(db).Table(stmt.Table).Where(clause.Or(exprs...)).Find(&rows).Error; err != nil {
+		_ = db.AddError(fmt.Errorf("audit: load rows: %w", err))
+		return nil
+	}
+	res := make(map[string]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		res[rowID(pks, row)] = row
+	}
+	return res
+}
+
+func (p *AuditPlugin) newLog(stmt *gorm.Statement, action, id string, diff map[string][2]interface{}) AuditLog {
+	data, _ := json.Marshal(diff)
+	return AuditLog{
+		Table:     stmt.Table,
+		RowID:     id,
+		Action:    action,
+		Actor:     ActorFromContext(stmt.Context),
+		Diff:      string(data),
+		CreatedAt: time.Now(),
+	}
+}
+
+func (p *AuditPlugin) writeLogs(db *gorm.DB, logs []AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	if err := auditSession(db).Table(p.table).Create(&logs).Error; err != nil {
+		_ = db.AddError(fmt.Errorf("audit: write log: %w", err))
+	}
+}
+
+func rowID(pks []string, row map[string]interface{}) string {
+	parts := make([]string, 0, len(pks))
+	for _, pk := range pks {
+		parts = append(parts, fmt.Sprint(normalizeAuditValue(row[pk])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func normalizeAuditValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// diffRow 返回变化的列，newRow 为 nil 表示行被删除
+func diffRow(oldRow, newRow map[string]interface{}) map[string][2]interface{} {
+	diff := make(map[string][2]interface{})
+	for col, ov := range oldRow {
+		ov = normalizeAuditValue(ov)
+		if newRow == nil {
+			diff[col] = [2]interface{}{ov, nil}
+			continue
+		}
+		nv := normalizeAuditValue(newRow[col])
+		if !reflect.DeepEqual(ov, nv) {
+			diff[col] = [2]interface{}{ov, nv}
+		}
+	}
+	return diff
+}
@@ -0,0 +1,46 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type auditOrder struct {
+	ID    int64
+	Title string
+	AuditModel
+}
+
+func TestAuditPlugin_DryRun(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true, DryRun: true, SkipDefaultTransaction: true})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Use(NewAuditPlugin()))
+	ctx := WithActor(context.Background(), "alice")
+
+	order := auditOrder{Title: "a"}
+	stmt := db.WithContext(ctx).Create(&order).Statement
+	assert.Equal(t, "alice", order.CreatedBy)
+	assert.Equal(t, "alice", order.UpdatedBy)
+	assert.Equal(t, int64(1), order.Version)
+	assert.Contains(t, stmt.SQL.String(), "`created_by`")
+
+	order.ID = 3
+	stmt = db.WithContext(WithActor(ctx, "bob")).Model(&order).Update("title", "b").Statement
+	assert.Contains(t, stmt.SQL.String(), "`version`=?")
+	assert.Contains(t, stmt.SQL.String(), "`audit_orders`.`version` = ?")
+	assert.Equal(t, int64(2), order.Version)
+	assert.Equal(t, "bob", order.UpdatedBy)
+}
+
+func TestDiffRow(t *testing.T) {
+	diff := diffRow(map[string]interface{}{"id": 1, "name": []byte("a"), "age": 3}, map[string]interface{}{"id": 1, "name": "b", "age": 3})
+	assert.Equal(t, map[string][2]interface{}{"name": {"a", "b"}}, diff)
+	diff = diffRow(map[string]interface{}{"id": 1}, nil)
+	assert.Equal(t, map[string][2]interface{}{"id": {1, nil}}, diff)
+	assert.Equal(t, "1,a", rowID([]string{"id", "k"}, map[string]interface{}{"id": 1, "k": []byte("a")}))
+}
@@ -0,0 +1,177 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Batch 把多个命令放在一次 pipeline（或 MULTI/EXEC）中执行。
+// 每个方法返回 go-redis 的命令对象，Exec 之后通过它读取各自的结果；key 会加上 RedisDB 的前缀。
+//
+//	b := rds.Batch()
+//	name := b.HGet("user:1", "name")
+//	cnt := b.Incr("user:1:visits")
+//	if err := b.Exec(ctx); err != nil { ... }
+//	name.Val(), cnt.Val()
+type Batch struct {
+	db   *RedisDB
+	pipe redis.Pipeliner
+	ctx  context.Context
+}
+
+// Batch 使用普通 pipeline，命令之间不保证原子性
+func (sel *RedisDB) Batch() *Batch {
+	return &Batch{db: sel, pipe: sel.rds.Pipeline(), ctx: context.Background()}
+}
+
+// TxBatch 使用 MULTI/EXEC，所有命令原子执行。cluster 模式下所有 key 需要在同一个 slot
+func (sel *RedisDB) TxBatch() *Batch {
+	return &Batch{db: sel, pipe: sel.rds.TxPipeline(), ctx: context.Background()}
+}
+
+// Len 已排队的命令数
+func (b *Batch) Len() int {
+	return b.pipe.Len()
+}
+
+// Discard 丢弃已排队的命令
+func (b *Batch) Discard() {
+	b.pipe.Discard()
+}
+
+// Exec 执行所有命令，返回第一个错误（redis.Nil 除外），每个命令的结果和错误保存在各自的命令对象中
+func (b *Batch) Exec(ctx context.Context) error {
+	if b.pipe.Len() == 0 {
+		return nil
+	}
+	cmds, err := b.pipe.Exec(ctx)
+	if err == nil || !errors.Is(err, redis.Nil) {
+		return err
+	}
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+			return cmdErr
+		}
+	}
+	return nil
+}
+
+func (b *Batch) Get(key string) *redis.StringCmd {
+	return b.pipe.Get(b.ctx, b.db.Key(key))
+}
+
+func (b *Batch) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return b.pipe.Set(b.ctx, b.db.Key(key), value, expiration)
+}
+
+func (b *Batch) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	return b.pipe.SetNX(b.ctx, b.db.Key(key), value, expiration)
+}
+
+func (b *Batch) MGet(keys ...string) *redis.SliceCmd {
+	return b.pipe.MGet(b.ctx, b.db.keys(keys)...)
+}
+
+func (b *Batch) Incr(key string) *redis.IntCmd {
+	return b.pipe.Incr(b.ctx, b.db.Key(key))
+}
+
+func (b *Batch) IncrBy(key string, value int64) *redis.IntCmd {
+	return b.pipe.IncrBy(b.ctx, b.db.Key(key), value)
+}
+
+func (b *Batch) Del(keys ...string) *redis.IntCmd {
+	return b.pipe.Del(b.ctx, b.db.keys(keys)...)
+}
+
+func (b *Batch) Exists(keys ...string) *redis.IntCmd {
+	return b.pipe.Exists(b.ctx, b.db.keys(keys)...)
+}
+
+func (b *Batch) Expire(key string, expiration time.Duration) *redis.BoolCmd {
+	return b.pipe.Expire(b.ctx, b.db.Key(key), expiration)
+}
+
+func (b *Batch) ExpireAt(key string, tm time.Time) *redis.BoolCmd {
+	return b.pipe.ExpireAt(b.ctx, b.db.Key(key), tm)
+}
+
+func (b *Batch) TTL(key string) *redis.DurationCmd {
+	return b.pipe.TTL(b.ctx, b.db.Key(key))
+}
+
+// HSet values 支持 field1, value1, ...、map[string]interface{} 和 map[string]string
+func (b *Batch) HSet(key string, values ...interface{}) *redis.IntCmd {
+	return b.pipe.HSet(b.ctx, b.db.Key(key), values...)
+}
+
+func (b *Batch) HGet(key, field string) *redis.StringCmd {
+	return b.pipe.HGet(b.ctx, b.db.Key(key), field)
+}
+
+func (b *Batch) HMGet(key string, fields ...string) *redis.SliceCmd {
+	return b.pipe.HMGet(b.ctx, b.db.Key(key), fields...)
+}
+
+func (b *Batch) HGetAll(key string) *redis.MapStringStringCmd {
+	return b.pipe.HGetAll(b.ctx, b.db.Key(key))
+}
+
+func (b *Batch) HDel(key string, fields ...string) *redis.IntCmd {
+	return b.pipe.HDel(b.ctx, b.db.Key(key), fields...)
+}
+
+func (b *Batch) HIncrBy(key, field string, incr int64) *redis.IntCmd {
+	return b.pipe.HIncrBy(b.ctx, b.db.Key(key), field, incr)
+}
+
+func (b *Batch) LPush(key string, values ...interface{}) *redis.IntCmd {
+	return b.pipe.LPush(b.ctx, b.db.Key(key), values...)
+}
+
+func (b *Batch) RPush(key string, values ...interface{}) *redis.IntCmd {
+	return b.pipe.RPush(b.ctx, b.db.Key(key), values...)
+}
+
+func (b *Batch) LTrim(key string, start, stop int64) *redis.StatusCmd {
+	return b.pipe.LTrim(b.ctx, b.db.Key(key), start, stop)
+}
+
+func (b *Batch) LRange(key string, start, stop int64) *redis.StringSliceCmd {
+	return b.pipe.LRange(b.ctx, b.db.Key(key), start, stop)
+}
+
+func (b *Batch) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	return b.pipe.SAdd(b.ctx, b.db.Key(key), members...)
+}
+
+func (b *Batch) SRem(key string, members ...interface{}) *redis.IntCmd {
+	return b.pipe.SRem(b.ctx, b.db.Key(key), members...)
+}
+
+func (b *Batch) SIsMember(key string, member interface{}) *redis.BoolCmd {
+	return b.pipe.SIsMember(b.ctx, b.db.Key(key), member)
+}
+
+func (b *Batch) ZAdd(key string, members ...redis.Z) *redis.IntCmd {
+	return b.pipe.ZAdd(b.ctx, b.db.Key(key), members...)
+}
+
+func (b *Batch) ZIncrBy(key string, increment float64, member string) *redis.FloatCmd {
+	return b.pipe.ZIncrBy(b.ctx, b.db.Key(key), increment, member)
+}
+
+func (b *Batch) ZScore(key, member string) *redis.FloatCmd {
+	return b.pipe.ZScore(b.ctx, b.db.Key(key), member)
+}
+
+func (b *Batch) ZRem(key string, members ...interface{}) *redis.IntCmd {
+	return b.pipe.ZRem(b.ctx, b.db.Key(key), members...)
+}
+
+func (b *Batch) ZRemRangeByRank(key string, start, stop int64) *redis.IntCmd {
+	return b.pipe.ZRemRangeByRank(b.ctx, b.db.Key(key), start, stop)
+}
@@ -0,0 +1,130 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs/redistest"
+)
+
+// newTestRedisDB 连接进程内的 redistest 服务，不修改全局默认的 RedisDB
+func newTestRedisDB(t *testing.T, opts ...RedisDBOption) (*redistest.Server, *RedisDB) {
+	srv := redistest.Run(t)
+	client := MustRedisClient(RedisConfig{Addr: srv.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	rds := &RedisDB{rds: client}
+	for _, opt := range opts {
+		opt(rds)
+	}
+	return srv, rds
+}
+
+func TestRedisDBKeyPrefix(t *testing.T) {
+	rds := &RedisDB{prefix: "svc:dev:"}
+	assert.Equal(t, "svc:dev:user:1", rds.Key("user:1"))
+	assert.Equal(t, []string{"svc:dev:a", "svc:dev:b"}, rds.keys([]string{"a", "b"}))
+	assert.Equal(t, []interface{}{"svc:dev:a", 1, "svc:dev:b", "x"}, rds.prefixPairs([]interface{}{"a", 1, "b", "x"}))
+	assert.Equal(t, []interface{}{"svc:dev:a", "1"}, rds.prefixPairs([]interface{}{[]string{"a", "1"}}))
+	assert.Equal(t, []interface{}{map[string]string{"svc:dev:a": "1"}}, rds.prefixPairs([]interface{}{map[string]string{"a": "1"}}))
+
+	none := &RedisDB{}
+	keys := []string{"a"}
+	assert.Equal(t, keys, none.keys(keys))
+}
+
+func TestBatchQueue(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	rds := &RedisDB{rds: client}
+	WithKeyPrefix("app:")(rds)
+
+	b := rds.Batch()
+	get := b.Get("k")
+	hset := b.HSet("h", map[string]string{"f": "v"})
+	del := b.Del("a", "b")
+	b.Expire("h", time.Minute)
+	assert.Equal(t, 4, b.Len())
+	assert.Equal(t, []interface{}{"get", "app:k"}, get.Args())
+	assert.Equal(t, []interface{}{"hset", "app:h", "f", "v"}, hset.Args())
+	assert.Equal(t, []interface{}{"del", "app:a", "app:b"}, del.Args())
+
+	b.Discard()
+	assert.Equal(t, 0, b.Len())
+	assert.NoError(t, b.Exec(context.Background()))
+}
+
+func TestRedisDBCommands(t *testing.T) {
+	_, rds := newTestRedisDB(t, WithKeyPrefix("app:"))
+
+	_, err := rds.MSet("a", "1", "b", "2")
+	require.NoError(t, err)
+	vals, err := rds.MGet("a", "b", "c")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"1", "2", nil}, vals)
+
+	raw := rds.rds.(*redis.Client)
+	v, err := raw.Get(context.Background(), "app:a").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "1", v)
+
+	res, err := rds.Eval(`return redis.call("INCRBY", KEYS[1], ARGV[1])`, []string{"a"}, 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), res)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rds.ZAddWithMaxSizeEx("z", int64(i), string(rune('a'+i)), 3, 60))
+	}
+	members, err := raw.ZRange(context.Background(), "app:z", 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d", "e"}, members)
+	ttl, err := raw.TTL(context.Background(), "app:z").Result()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rds.LPushWithMaxSizeEx("l", string(rune('a'+i)), 3, 60))
+	}
+	items, err := raw.LRange(context.Background(), "app:l", 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e", "d", "c"}, items)
+
+	require.NoError(t, rds.HSetEx("h", "f", "v", 60))
+	require.NoError(t, rds.HMSetEx("h", map[string]string{"g": "w", "x": "y"}, 120))
+	hash, err := raw.HGetAll(context.Background(), "app:h").Result()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"f": "v", "g": "w", "x": "y"}, hash)
+	ttl, err = raw.TTL(context.Background(), "app:h").Result()
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, ttl)
+
+	// 空 map 只刷新过期时间
+	require.NoError(t, rds.HMSetEx("h", map[string]string{}, 180))
+	ttl, err = raw.TTL(context.Background(), "app:h").Result()
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Minute, ttl)
+}
+
+func TestBatchExec(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+
+	b := rds.TxBatch()
+	b.Set("k", "v", time.Minute)
+	incr := b.IncrBy("n", 3)
+	hset := b.HSet("h", "f", "v")
+	get := b.Get("missing")
+	require.NoError(t, b.Exec(ctx))
+	assert.Equal(t, int64(3), incr.Val())
+	assert.Equal(t, int64(1), hset.Val())
+	assert.Equal(t, redis.Nil, get.Err())
+
+	b = rds.Batch()
+	b.Set("n", "x", 0)
+	bad := b.Incr("n")
+	assert.Error(t, b.Exec(ctx))
+	assert.Error(t, bad.Err())
+}
@@ -0,0 +1,235 @@
+package dbs
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	bloomMaxBits    = 1 << 32 // redis 字符串最大 512MB
+	bloomGrowth     = 2       // 每一层的容量是上一层的 2 倍
+	bloomTightening = 0.5     // 每一层的误判率是上一层的一半
+)
+
+// BloomSize 根据预计元素数量 n 和误判率 p 计算位数 m 和哈希函数个数 k
+func BloomSize(n int64, p float64) (m uint64, k int) {
+	if n <= 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	fm := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	if fm > bloomMaxBits {
+		fm = bloomMaxBits
+	}
+	m = uint64(fm)
+	k = int(math.Round(fm / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return m, k
+}
+
+// bloomPositions 双重哈希 h1 + i*h2 计算 k 个位置
+func bloomPositions(item string, m uint64, k int) []int64 {
+	h1 := fnv.New64a()
+	_, _ = h1.Write([]byte(item))
+	h2 := fnv.New64()
+	_, _ = h2.Write([]byte(item))
+	a, b := h1.Sum64(), h2.Sum64()|1
+	pos := make([]int64, k)
+	for i := 0; i < k; i++ {
+		pos[i] = int64((a + uint64(i)*b) % m)
+	}
+	return pos
+}
+
+type bloomLayer struct {
+	key      string
+	capacity int64
+	m        uint64
+	k        int
+}
+
+type BloomOption func(b *BloomFilter)
+
+// WithBloomTTL 所有层的过期时间，默认不过期
+func WithBloomTTL(ttl time.Duration) BloomOption {
+	return func(b *BloomFilter) {
+		b.ttl = ttl
+	}
+}
+
+// BloomFilter 基于 redis bitmap 的可扩展布隆过滤器，哈希在客户端计算，位操作通过 pipeline 批量执行。
+// 第一层按 capacity 和 fpRate/2 分配，写满后新增容量翻倍、误判率减半的一层，总误判率不超过 fpRate。
+// 数据保存在 {key}:0、{key}:1 ... 和 {key}:meta 中
+type BloomFilter struct {
+	rds      redis.Cmdable
+	key      string
+	capacity int64
+	fpRate   float64
+	ttl      time.Duration
+}
+
+func NewBloomFilter(rds *RedisDB, key string, capacity int64, fpRate float64, opts ...BloomOption) *BloomFilter {
+	b := &BloomFilter{rds: rds.rds, key: rds.Key(key), capacity: capacity, fpRate: fpRate}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *BloomFilter) metaKey() string {
+	return "{" + b.key + "}:meta"
+}
+
+func (b *BloomFilter) layer(i int) bloomLayer {
+	capacity := b.capacity
+	p := b.fpRate * (1 - bloomTightening)
+	for j := 0; j < i; j++ {
+		capacity *= bloomGrowth
+		p *= bloomTightening
+	}
+	m, k := BloomSize(capacity, p)
+	return bloomLayer{key: "{" + b.key + "}:" + strconv.Itoa(i), capacity: capacity, m: m, k: k}
+}
+
+func (b *BloomFilter) layers(ctx context.Context) (int, error) {
+	n, err := b.rds.HGet(ctx, b.metaKey(), "layers").Int()
+	if err == redis.Nil {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Exists 判断元素是否可能存在，返回 false 时一定不存在
+func (b *BloomFilter) Exists(ctx context.Context, item string) (bool, error) {
+	res, err := b.ExistsMany(ctx, item)
+	if err != nil {
+		return false, err
+	}
+	return res[0], nil
+}
+
+// ExistsMany 批量判断，结果与 items 一一对应
+func (b *BloomFilter) ExistsMany(ctx context.Context, items ...string) ([]bool, error) {
+	n, err := b.layers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return b.exists(ctx, n, items)
+}
+
+func (b *BloomFilter) exists(ctx context.Context, layers int, items []string) ([]bool, error) {
+	res := make([]bool, len(items))
+	if len(items) == 0 {
+		return res, nil
+	}
+	cmds := make([][][]*redis.IntCmd, len(items))
+	_, err := b.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, item := range items {
+			cmds[i] = make([][]*redis.IntCmd, layers)
+			for l := 0; l < layers; l++ {
+				ly := b.layer(l)
+				for _, pos := range bloomPositions(item, ly.m, ly.k) {
+					cmds[i][l] = append(cmds[i][l], pipe.GetBit(ctx, ly.key, pos))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		for l := 0; l < layers && !res[i]; l++ {
+			all := true
+			for _, cmd := range cmds[i][l] {
+				if cmd.Val() == 0 {
+					all = false
+					break
+				}
+			}
+			res[i] = all
+		}
+	}
+	return res, nil
+}
+
+// Add 添加元素，返回新增的数量（已经可能存在的元素不计数）。当前层写满后自动扩展一层
+func (b *BloomFilter) Add(ctx context.Context, items ...string) (int, error) {
+	layers, err := b.layers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	exists, err := b.exists(ctx, layers, items)
+	if err != nil {
+		return 0, err
+	}
+	ly := b.layer(layers - 1)
+	countField := "count:" + strconv.Itoa(layers-1)
+	var (
+		added    int
+		countCmd *redis.IntCmd
+		seen     = make(map[string]bool)
+	)
+	_, err = b.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, item := range items {
+			if exists[i] || seen[item] {
+				continue
+			}
+			seen[item] = true
+			added++
+			for _, pos := range bloomPositions(item, ly.m, ly.k) {
+				pipe.SetBit(ctx, ly.key, pos, 1)
+			}
+		}
+		if added == 0 {
+			return nil
+		}
+		countCmd = pipe.HIncrBy(ctx, b.metaKey(), countField, int64(added))
+		if b.ttl > 0 {
+			pipe.Expire(ctx, ly.key, b.ttl)
+			pipe.Expire(ctx, b.metaKey(), b.ttl)
+		}
+		return nil
+	})
+	if err != nil || countCmd == nil {
+		return added, err
+	}
+	if countCmd.Val() >= ly.capacity {
+		// 只有第一个发现写满的客户端负责扩展
+		ok, err := b.rds.HSetNX(ctx, b.metaKey(), "grow:"+strconv.Itoa(layers-1), 1).Result()
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			if err = b.rds.HSet(ctx, b.metaKey(), "layers", layers+1).Err(); err != nil {
+				return added, err
+			}
+		}
+	}
+	return added, nil
+}
+
+// Delete 删除所有层
+func (b *BloomFilter) Delete(ctx context.Context) error {
+	layers, err := b.layers(ctx)
+	if err != nil {
+		return err
+	}
+	keys := []string{b.metaKey()}
+	for i := 0; i < layers; i++ {
+		keys = append(keys, b.layer(i).key)
+	}
+	return b.rds.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,72 @@
+package dbs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomSize(t *testing.T) {
+	m, k := BloomSize(1000000, 0.01)
+	assert.Equal(t, uint64(9585059), m)
+	assert.Equal(t, 7, k)
+
+	m, k = BloomSize(0, 2)
+	assert.Equal(t, uint64(10), m)
+	assert.Equal(t, 7, k)
+}
+
+func TestBloomPositions(t *testing.T) {
+	pos := bloomPositions("hello", 1000, 7)
+	assert.Len(t, pos, 7)
+	assert.Equal(t, pos, bloomPositions("hello", 1000, 7))
+	assert.NotEqual(t, pos, bloomPositions("world", 1000, 7))
+	for _, p := range pos {
+		assert.True(t, p >= 0 && p < 1000)
+	}
+}
+
+func TestBloomLayer(t *testing.T) {
+	b := &BloomFilter{key: "users", capacity: 1000, fpRate: 0.01}
+	l0, l1 := b.layer(0), b.layer(1)
+	assert.Equal(t, "{users}:0", l0.key)
+	assert.Equal(t, "{users}:1", l1.key)
+	assert.Equal(t, int64(2000), l1.capacity)
+	assert.Greater(t, l1.m, 2*l0.m)
+	assert.Greater(t, l1.k, l0.k)
+}
+
+func TestBloomFilterRedis(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	b := NewBloomFilter(rds, "seen", 100, 0.01)
+
+	items := make([]string, 250)
+	for i := range items {
+		items[i] = fmt.Sprintf("item-%d", i)
+	}
+	for i := 0; i < len(items); i += 50 {
+		_, err := b.Add(ctx, items[i:i+50]...)
+		require.NoError(t, err)
+	}
+	layers, err := b.layers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, layers)
+
+	exists, err := b.ExistsMany(ctx, items...)
+	require.NoError(t, err)
+	for i, ok := range exists {
+		assert.True(t, ok, items[i])
+	}
+	added, err := b.Add(ctx, items[0])
+	require.NoError(t, err)
+	assert.Equal(t, 0, added)
+
+	require.NoError(t, b.Delete(ctx))
+	ok, err := b.Exists(ctx, items[0])
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,65 @@
+package dbs
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeBucket 按时间分桶的粒度，用于去重计数和排行榜
+type TimeBucket int
+
+const (
+	BucketHourly TimeBucket = iota
+	BucketDaily
+	BucketWeekly
+)
+
+// key 返回 t 所在时间桶的后缀
+func (b TimeBucket) key(t time.Time) string {
+	switch b {
+	case BucketHourly:
+		return t.Format("2006010215")
+	case BucketWeekly:
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%04dW%02d", y, w)
+	default:
+		return t.Format("20060102")
+	}
+}
+
+// start 返回 t 所在时间桶的起点
+func (b TimeBucket) start(t time.Time) time.Time {
+	switch b {
+	case BucketHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case BucketWeekly:
+		d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		wd := (int(d.Weekday()) + 6) % 7 // 周一为第一天
+		return d.AddDate(0, 0, -wd)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+func (b TimeBucket) next(t time.Time) time.Time {
+	switch b {
+	case BucketHourly:
+		return t.Add(time.Hour)
+	case BucketWeekly:
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// span 时间桶的长度（按 24 小时一天计算）
+func (b TimeBucket) span() time.Duration {
+	switch b {
+	case BucketHourly:
+		return time.Hour
+	case BucketWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
@@ -0,0 +1,179 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultBulkBatchSize  = 500
+	defaultBulkBatchBytes = 4 << 20
+)
+
+var (
+	ErrChunkSkipped = errors.New("bulk chunk skipped after previous error")
+)
+
+type BulkOptions struct {
+	BatchSize       int      // 每批最大行数，默认 500
+	MaxBatchBytes   int      // 每批估算的最大字节数，默认 4MB，需要小于 max_allowed_packet
+	UpdateColumns   []string // 主键或唯一键冲突时更新的列，为空时更新除主键外的所有列
+	IgnoreConflict  bool     // 冲突时不更新任何列，优先于 UpdateColumns
+	Parallel        int      // 同时执行的批次数，默认 1；在事务中始终串行
+	ContinueOnError bool     // 某一批失败后继续执行后面的批次，否则后面的批次标记为 ErrChunkSkipped
+}
+
+func (sel *BulkOptions) GetBatchSize() int {
+	return getIntWithDefault(sel.BatchSize, defaultBulkBatchSize)
+}
+
+func (sel *BulkOptions) GetMaxBatchBytes() int {
+	return getIntWithDefault(sel.MaxBatchBytes, defaultBulkBatchBytes)
+}
+
+func (sel *BulkOptions) GetParallel() int {
+	return getIntWithDefault(sel.Parallel, 1)
+}
+
+// ChunkResult 单个批次的结果，[Start, End) 为批次在 rows 中的范围
+type ChunkResult struct {
+	Index        int
+	Start        int
+	End          int
+	RowsAffected int64 // mysql 中插入的行计 1，更新的行计 2
+	Err          error
+}
+
+type BulkResult struct {
+	Chunks       []ChunkResult
+	RowsAffected int64
+}
+
+// BulkUpsert 分批执行 INSERT ... ON DUPLICATE KEY UPDATE，批次按行数和估算的字节数切分。
+// 返回的 error 合并了所有失败批次的错误，具体哪一批失败看 BulkResult.Chunks
+func BulkUpsert[T any](ctx context.Context, db *gorm.DB, rows []T, opts BulkOptions) (*BulkResult, error) {
+	res := &BulkResult{}
+	if len(rows) == 0 {
+		return res, nil
+	}
+	db = db.WithContext(ctx)
+	sizes, err := estimateRowSizes(db, rows)
+	if err != nil {
+		return nil, err
+	}
+	ranges := splitChunks(sizes, opts.GetBatchSize(), opts.GetMaxBatchBytes())
+	res.Chunks = make([]ChunkResult, len(ranges))
+
+	conflict := clause.OnConflict{UpdateAll: true}
+	if opts.IgnoreConflict {
+		conflict = clause.OnConflict{DoNothing: true}
+	} else if len(opts.UpdateColumns) > 0 {
+		conflict = clause.OnConflict{DoUpdates: clause.AssignmentColumns(opts.UpdateColumns)}
+	}
+
+	parallel := opts.GetParallel()
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		parallel = 1
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+		sem    = make(chan struct{}, parallel)
+	)
+	for i, r := range ranges {
+		res.Chunks[i] = ChunkResult{Index: i, Start: r[0], End: r[1]}
+		sem <- struct{}{}
+		mu.Lock()
+		skip := failed && !opts.ContinueOnError
+		mu.Unlock()
+		if skip {
+			<-sem
+			res.Chunks[i].Err = ErrChunkSkipped
+			continue
+		}
+		wg.Add(1)
+		go func(i int, chunk []T) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			tx := db.Session(&gorm.Session{CreateBatchSize: len(chunk)}).Clauses(conflict).Create(&chunk)
+			mu.Lock()
+			defer mu.Unlock()
+			res.Chunks[i].RowsAffected = tx.RowsAffected
+			if tx.Error != nil {
+				res.Chunks[i].Err = tx.Error
+				failed = true
+			}
+		}(i, rows[r[0]:r[1]])
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, c := range res.Chunks {
+		res.RowsAffected += c.RowsAffected
+		if c.Err != nil && !errors.Is(c.Err, ErrChunkSkipped) {
+			errs = append(errs, fmt.Errorf("chunk %d [%d,%d): %w", c.Index, c.Start, c.End, c.Err))
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// estimateRowSizes 估算每一行在 sql 中的字节数，字符串按长度计算，其他类型按固定长度
+func estimateRowSizes[T any](db *gorm.DB, rows []T) ([]int, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&rows[0]); err != nil {
+		return nil, err
+	}
+	sizes := make([]int, len(rows))
+	for i := range rows {
+		rv := reflect.ValueOf(&rows[i]).Elem()
+		size := 4
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName == "" {
+				continue
+			}
+			v, _ := f.ValueOf(stmt.Context, rv)
+			switch val := v.(type) {
+			case string:
+				size += len(val) + 3
+			case []byte:
+				size += 2*len(val) + 3
+			case *string:
+				if val != nil {
+					size += len(*val) + 3
+				} else {
+					size += 5
+				}
+			default:
+				size += 16
+			}
+		}
+		sizes[i] = size
+	}
+	return sizes, nil
+}
+
+// splitChunks 按行数和字节数切分，单行超过 maxBytes 时独占一批
+func splitChunks(sizes []int, maxRows, maxBytes int) [][2]int {
+	var (
+		ranges [][2]int
+		start  int
+		bytes  int
+	)
+	for i, s := range sizes {
+		if i > start && (i-start >= maxRows || bytes+s > maxBytes) {
+			ranges = append(ranges, [2]int{start, i})
+			start, bytes = i, 0
+		}
+		bytes += s
+	}
+	return append(ranges, [2]int{start, len(sizes)})
+}
@@ -0,0 +1,44 @@
+package dbs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestSplitChunks(t *testing.T) {
+	assert.Equal(t, [][2]int{{0, 2}, {2, 4}, {4, 5}}, splitChunks([]int{1, 1, 1, 1, 1}, 2, 100))
+	assert.Equal(t, [][2]int{{0, 2}, {2, 3}, {3, 4}}, splitChunks([]int{4, 5, 20, 3}, 10, 10))
+	assert.Equal(t, [][2]int{{0, 1}}, splitChunks([]int{1}, 10, 10))
+}
+
+type bulkUser struct {
+	ID   int64
+	Name string
+}
+
+func TestBulkUpsert_DryRun(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true, DryRun: true, SkipDefaultTransaction: true})
+	assert.NoError(t, err)
+
+	var sqls []string
+	assert.NoError(t, db.Callback().Create().After("gorm:create").Register("test:sql", func(db *gorm.DB) {
+		sqls = append(sqls, db.Statement.SQL.String())
+	}))
+	rows := make([]bulkUser, 5)
+	for i := range rows {
+		rows[i] = bulkUser{ID: int64(i + 1), Name: strings.Repeat("x", 10)}
+	}
+	res, err := BulkUpsert(context.Background(), db, rows, BulkOptions{BatchSize: 2, UpdateColumns: []string{"name"}})
+	assert.NoError(t, err)
+	assert.Len(t, res.Chunks, 3)
+	if assert.Len(t, sqls, 3) {
+		assert.Contains(t, sqls[0], "ON DUPLICATE KEY UPDATE `name`=VALUES(`name`)")
+		assert.Equal(t, 2, strings.Count(sqls[0], "(?,?)"))
+	}
+}
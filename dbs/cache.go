@@ -0,0 +1,278 @@
+package dbs
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ville-vv/gutils/zlog"
+	"gorm.io/gorm"
+)
+
+const (
+	cacheFlagValue    byte = 'v'
+	cacheFlagNotFound byte = 'n'
+	cacheHeaderLen         = 1 + 8 + 4 // flag + 过期时间(ms) + 加载耗时(ms)
+)
+
+var (
+	ErrCacheNotFound  = errors.New("cache: not found")
+	ErrNoDefaultRedis = errors.New("dbs: default redis db is not initialized, call InitRedisDB or SetDefaultRedisDB first")
+
+	_defaultRedis   *RedisDB
+	_defaultRedisMu sync.RWMutex
+	_cacheFlight    flightGroup
+)
+
+// SetDefaultRedisDB 设置 Cached 使用的默认 redis，InitRedisDB 在没有默认值时会自动设置
+func SetDefaultRedisDB(rds *RedisDB) {
+	_defaultRedisMu.Lock()
+	defer _defaultRedisMu.Unlock()
+	_defaultRedis = rds
+}
+
+func defaultRedisDB() (*RedisDB, error) {
+	_defaultRedisMu.RLock()
+	defer _defaultRedisMu.RUnlock()
+	if _defaultRedis == nil {
+		return nil, ErrNoDefaultRedis
+	}
+	return _defaultRedis, nil
+}
+
+// Codec 缓存值的序列化方式
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type CacheOptions struct {
+	codec       Codec
+	negativeTTL time.Duration
+	jitter      float64
+	beta        float64
+	rds         *RedisDB
+}
+
+type CacheOption func(o *CacheOptions)
+
+// WithCacheCodec 序列化方式，默认 json
+func WithCacheCodec(c Codec) CacheOption {
+	return func(o *CacheOptions) {
+		o.codec = c
+	}
+}
+
+// WithNegativeTTL loader 返回不存在时缓存空结果的时间，默认 30s，小于 0 时不缓存空结果
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(o *CacheOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithTTLJitter ttl 随机增加 [0, ttl*ratio) 避免同时过期，默认 0.1
+func WithTTLJitter(ratio float64) CacheOption {
+	return func(o *CacheOptions) {
+		o.jitter = ratio
+	}
+}
+
+// WithEarlyRefresh 提前刷新系数，越大越早刷新，默认 1，0 表示不提前刷新
+func WithEarlyRefresh(beta float64) CacheOption {
+	return func(o *CacheOptions) {
+		o.beta = beta
+	}
+}
+
+// WithCacheRedis 使用指定的 redis，默认使用 SetDefaultRedisDB 设置的 redis
+func WithCacheRedis(rds *RedisDB) CacheOption {
+	return func(o *CacheOptions) {
+		o.rds = rds
+	}
+}
+
+func getCacheOptions(opts ...CacheOption) (*CacheOptions, error) {
+	o := &CacheOptions{codec: JSONCodec{}, negativeTTL: 30 * time.Second, jitter: 0.1, beta: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.rds == nil {
+		rds, err := defaultRedisDB()
+		if err != nil {
+			return nil, err
+		}
+		o.rds = rds
+	}
+	return o, nil
+}
+
+type cacheEntry struct {
+	notFound bool
+	expireAt time.Time
+	delta    time.Duration
+	data     []byte
+}
+
+func encodeCacheEntry(e *cacheEntry) []byte {
+	buf := make([]byte, cacheHeaderLen+len(e.data))
+	buf[0] = cacheFlagValue
+	if e.notFound {
+		buf[0] = cacheFlagNotFound
+	}
+	binary.BigEndian.PutUint64(buf[1:9], uint64(e.expireAt.UnixMilli()))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(e.delta.Milliseconds()))
+	copy(buf[cacheHeaderLen:], e.data)
+	return buf
+}
+
+func decodeCacheEntry(b []byte) (*cacheEntry, bool) {
+	if len(b) < cacheHeaderLen || (b[0] != cacheFlagValue && b[0] != cacheFlagNotFound) {
+		return nil, false
+	}
+	return &cacheEntry{
+		notFound: b[0] == cacheFlagNotFound,
+		expireAt: time.UnixMilli(int64(binary.BigEndian.Uint64(b[1:9]))),
+		delta:    time.Duration(binary.BigEndian.Uint32(b[9:13])) * time.Millisecond,
+		data:     b[cacheHeaderLen:],
+	}, true
+}
+
+// shouldRefresh XFetch 算法：now - delta*beta*ln(rand) >= expireAt 时提前刷新，
+// 加载越慢、越接近过期，刷新的概率越大
+func (e *cacheEntry) shouldRefresh(beta float64, now time.Time) bool {
+	if beta <= 0 || e.delta <= 0 {
+		return false
+	}
+	gap := time.Duration(-float64(e.delta) * beta * math.Log(rand.Float64()))
+	return !now.Add(gap).Before(e.expireAt)
+}
+
+func jitterTTL(ttl time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Float64()*ratio*float64(ttl))
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrCacheNotFound) || errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// Cached 旁路缓存：先读 redis，未命中时调用 loader 并写回。
+// 同一进程内同一个 key 只有一个 loader 在执行；loader 返回 ErrCacheNotFound 或 gorm.ErrRecordNotFound 时
+// 缓存空结果，命中空结果返回 ErrCacheNotFound；快过期时按概率在后台提前刷新。redis 不可用时直接调用 loader，
+// 没有通过 WithCacheRedis 指定 redis 也没有设置默认 redis 时返回 ErrNoDefaultRedis
+func Cached[T any](ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), opts ...CacheOption) (T, error) {
+	var zero T
+	o, err := getCacheOptions(opts...)
+	if err != nil {
+		return zero, err
+	}
+	key = o.rds.Key(key)
+	raw, err := o.rds.rds.Get(ctx, key).Bytes()
+	if err == nil {
+		if e, ok := decodeCacheEntry(raw); ok {
+			if e.shouldRefresh(o.beta, time.Now()) && !_cacheFlight.Running(cacheFlightKey[T](key)) {
+				bg := context.WithoutCancel(ctx)
+				go func() {
+					_, _ = loadCache(bg, o, key, ttl, loader)
+				}()
+			}
+			if e.notFound {
+				return zero, ErrCacheNotFound
+			}
+			var v T
+			if err = o.codec.Unmarshal(e.data, &v); err == nil {
+				return v, nil
+			}
+			zlog.WithContext(ctx).Warnf("cache: unmarshal %s: %v", key, err)
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		zlog.WithContext(ctx).Warnf("cache: get %s: %v", key, err)
+	}
+	v, err := loadCache(ctx, o, key, ttl, loader)
+	if err != nil {
+		return zero, err
+	}
+	return v, nil
+}
+
+func loadCache[T any](ctx context.Context, o *CacheOptions, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	v, err, _ := _cacheFlight.Do(cacheFlightKey[T](key), func() (interface{}, error) {
+		begin := time.Now()
+		val, err := loader(ctx)
+		delta := time.Since(begin)
+		e := &cacheEntry{delta: delta}
+		var exp time.Duration
+		switch {
+		case err == nil:
+			if e.data, err = o.codec.Marshal(val); err != nil {
+				return nil, err
+			}
+			exp = jitterTTL(ttl, o.jitter)
+		case isNotFound(err):
+			if o.negativeTTL <= 0 {
+				return nil, ErrCacheNotFound
+			}
+			e.notFound = true
+			exp = jitterTTL(o.negativeTTL, o.jitter)
+		default:
+			return nil, err
+		}
+		e.expireAt = time.Now().Add(exp)
+		if setErr := o.rds.rds.Set(ctx, key, encodeCacheEntry(e), exp).Err(); setErr != nil {
+			zlog.WithContext(ctx).Warnf("cache: set %s: %v", key, setErr)
+		}
+		if e.notFound {
+			return nil, ErrCacheNotFound
+		}
+		return val, nil
+	})
+	if err != nil {
+		return zero, err
+	}
+	if v == nil {
+		return zero, nil
+	}
+	val, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("cache: %s loaded %T, want %T", key, v, zero)
+	}
+	return val, nil
+}
+
+// cacheFlightKey 同一个 key 用不同类型调用 Cached 时不能共享 loader 的结果，flight key 带上类型
+func cacheFlightKey[T any](key string) string {
+	return key + "#" + reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+// InvalidateCache 删除缓存，opts 中只有 WithCacheRedis 生效，需要和写入时使用同一个 redis
+func InvalidateCache(ctx context.Context, keys []string, opts ...CacheOption) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	o, err := getCacheOptions(opts...)
+	if err != nil {
+		return err
+	}
+	return o.rds.rds.Del(ctx, o.rds.keys(keys)...).Err()
+}
@@ -0,0 +1,156 @@
+package dbs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestCacheEntry_EncodeDecode(t *testing.T) {
+	exp := time.UnixMilli(time.Now().UnixMilli())
+	b := encodeCacheEntry(&cacheEntry{expireAt: exp, delta: 120 * time.Millisecond, data: []byte(`{"a":1}`)})
+	e, ok := decodeCacheEntry(b)
+	if assert.True(t, ok) {
+		assert.False(t, e.notFound)
+		assert.True(t, exp.Equal(e.expireAt))
+		assert.Equal(t, 120*time.Millisecond, e.delta)
+		assert.Equal(t, `{"a":1}`, string(e.data))
+	}
+	e, ok = decodeCacheEntry(encodeCacheEntry(&cacheEntry{notFound: true, expireAt: exp}))
+	assert.True(t, ok)
+	assert.True(t, e.notFound)
+	_, ok = decodeCacheEntry([]byte("plain"))
+	assert.False(t, ok)
+}
+
+func TestCacheEntry_ShouldRefresh(t *testing.T) {
+	now := time.Now()
+	e := &cacheEntry{expireAt: now.Add(time.Hour), delta: time.Millisecond}
+	assert.False(t, e.shouldRefresh(1, now))
+	e = &cacheEntry{expireAt: now, delta: time.Millisecond}
+	assert.True(t, e.shouldRefresh(1, now))
+	assert.False(t, e.shouldRefresh(0, now))
+
+	ttl := jitterTTL(time.Second, 0.5)
+	assert.True(t, ttl >= time.Second && ttl < 1500*time.Millisecond)
+}
+
+func TestFlightGroup(t *testing.T) {
+	var (
+		g     flightGroup
+		calls int32
+		wg    sync.WaitGroup
+	)
+	start := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, err, _ := g.Do("k", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return 1, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 1, v)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.False(t, g.Running("k"))
+
+	_, err, _ := g.Do("p", func() (interface{}, error) { panic("boom") })
+	assert.Error(t, err)
+}
+
+func TestCachedRedis(t *testing.T) {
+	srv, rds := newTestRedisDB(t, WithKeyPrefix("app:"))
+	ctx := context.Background()
+	var calls int32
+	loader := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 42, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := Cached(ctx, "answer", time.Minute, loader, WithCacheRedis(rds), WithEarlyRefresh(0))
+		require.NoError(t, err)
+		assert.Equal(t, 42, v)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	srv.FastForward(2 * time.Minute)
+	_, err := Cached(ctx, "answer", time.Minute, loader, WithCacheRedis(rds), WithEarlyRefresh(0))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	missing := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, gorm.ErrRecordNotFound
+	}
+	for i := 0; i < 2; i++ {
+		_, err = Cached(ctx, "missing", time.Minute, missing, WithCacheRedis(rds), WithNegativeTTL(time.Minute))
+		assert.ErrorIs(t, err, ErrCacheNotFound)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestCached_FlightPerType(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v, err := Cached(ctx, "shared", time.Minute, func(ctx context.Context) (int, error) {
+			<-release
+			return 1, nil
+		}, WithCacheRedis(rds))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, v)
+	}()
+	require.Eventually(t, func() bool { return _cacheFlight.Running(cacheFlightKey[int](rds.Key("shared"))) }, time.Second, time.Millisecond)
+
+	// 不同类型不等待 int 的 loader，也不会拿到它的结果
+	v, err := Cached(ctx, "shared", time.Minute, func(ctx context.Context) (string, error) {
+		return "a", nil
+	}, WithCacheRedis(rds))
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	close(release)
+	<-done
+}
+
+func TestCached_NoDefaultRedis(t *testing.T) {
+	SetDefaultRedisDB(nil)
+	ctx := context.Background()
+	_, err := Cached(ctx, "k", time.Minute, func(ctx context.Context) (int, error) { return 1, nil })
+	assert.ErrorIs(t, err, ErrNoDefaultRedis)
+	assert.ErrorIs(t, InvalidateCache(ctx, []string{"k"}), ErrNoDefaultRedis)
+}
+
+func TestInvalidateCache(t *testing.T) {
+	_, rds := newTestRedisDB(t, WithKeyPrefix("app:"))
+	ctx := context.Background()
+	var calls int32
+	loader := func(ctx context.Context) (int, error) {
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}
+
+	v, err := Cached(ctx, "answer", time.Minute, loader, WithCacheRedis(rds), WithEarlyRefresh(0))
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+	require.NoError(t, InvalidateCache(ctx, []string{"answer"}, WithCacheRedis(rds)))
+	v, err = Cached(ctx, "answer", time.Minute, loader, WithCacheRedis(rds), WithEarlyRefresh(0))
+	require.NoError(t, err)
+	assert.Equal(t, 2, v)
+	assert.NoError(t, InvalidateCache(ctx, nil, WithCacheRedis(rds)))
+}
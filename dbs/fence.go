@@ -0,0 +1,55 @@
+package dbs
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFenceRejected = errors.New("update rejected by fencing token")
+)
+
+// FenceScope 只匹配 fence 列不大于 token 的行。
+// 同一个持有者可以用同一个 token 多次写入，持有更小 token 的过期持有者会被拒绝
+func FenceScope(column string, token int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Expr{SQL: "? <= ?", Vars: []interface{}{clause.Column{Name: column}, token}})
+	}
+}
+
+// UpdateWithFence 带 fencing token 的条件更新，同时把 fence 列写为 token。
+// db 需要已经指定 Model/Table 及业务条件；没有行被更新时再按业务条件查询一次：
+// 没有匹配的行返回 gorm.ErrRecordNotFound，有行的 fence 大于 token 返回 ErrFenceRejected，
+// 其余情况（例如 MySQL 重复写入相同的值时 RowsAffected 为 0）视为成功
+func UpdateWithFence(db *gorm.DB, column string, token int64, values map[string]interface{}) error {
+	db = db.Session(&gorm.Session{})
+	updates := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		updates[k] = v
+	}
+	updates[column] = token
+	res := db.Scopes(FenceScope(column, token)).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	var total, stale int64
+	if err := db.Count(&total).Error; err != nil {
+		return err
+	}
+	if total == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	err := db.Where(clause.Expr{SQL: "? > ?", Vars: []interface{}{clause.Column{Name: column}, token}}).Count(&stale).Error
+	if err != nil {
+		return err
+	}
+	if stale > 0 {
+		return ErrFenceRejected
+	}
+	return nil
+}
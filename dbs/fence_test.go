@@ -0,0 +1,76 @@
+package dbs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type fenceOrder struct {
+	ID     int64
+	Status string
+	Fence  int64
+}
+
+// newFenceTestDB DryRun 连接，affected 为 UPDATE 的 RowsAffected，total/stale 为两次 COUNT 的结果
+func newFenceTestDB(t *testing.T, affected, total, stale int64) (*gorm.DB, *[]string) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true, DryRun: true, SkipDefaultTransaction: true})
+	assert.NoError(t, err)
+	var sqls []string
+	assert.NoError(t, db.Callback().Update().After("gorm:update").Register("test:fence", func(tx *gorm.DB) {
+		sqls = append(sqls, tx.Statement.SQL.String())
+		tx.RowsAffected = affected
+	}))
+	assert.NoError(t, db.Callback().Query().After("gorm:query").Register("test:fence", func(tx *gorm.DB) {
+		sql := tx.Statement.SQL.String()
+		sqls = append(sqls, sql)
+		if n, ok := tx.Statement.Dest.(*int64); ok {
+			*n = total
+			if strings.Contains(sql, "`fence` >") {
+				*n = stale
+			}
+			tx.RowsAffected = 1
+		}
+	}))
+	return db, &sqls
+}
+
+func TestFenceScope(t *testing.T) {
+	db, _ := newFenceTestDB(t, 0, 0, 0)
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&fenceOrder{}).Where("id = ?", 1).Scopes(FenceScope("fence", 7)).Update("status", "paid")
+	})
+	assert.Contains(t, sql, "WHERE id = 1 AND `fence` <= 7")
+}
+
+func TestUpdateWithFence(t *testing.T) {
+	update := func(db *gorm.DB) error {
+		return UpdateWithFence(db.Model(&fenceOrder{}).Where("id = ?", 1), "fence", 7, map[string]interface{}{"status": "paid"})
+	}
+
+	db, sqls := newFenceTestDB(t, 1, 0, 0)
+	assert.NoError(t, update(db))
+	assert.Len(t, *sqls, 1)
+	assert.Contains(t, (*sqls)[0], "`fence`=?")
+	assert.Contains(t, (*sqls)[0], "WHERE id = ? AND `fence` <= ?")
+
+	// 没有匹配业务条件的行
+	db, sqls = newFenceTestDB(t, 0, 0, 0)
+	assert.ErrorIs(t, update(db), gorm.ErrRecordNotFound)
+	assert.Len(t, *sqls, 2)
+	assert.NotContains(t, (*sqls)[1], "`fence`")
+
+	// 行存在但 fence 已经大于 token
+	db, sqls = newFenceTestDB(t, 0, 1, 1)
+	assert.ErrorIs(t, update(db), ErrFenceRejected)
+	assert.Len(t, *sqls, 3)
+	assert.Contains(t, (*sqls)[2], "WHERE id = ? AND `fence` > ?")
+
+	// 行存在且 fence 未超过 token，只是值没有变化
+	db, _ = newFenceTestDB(t, 0, 1, 0)
+	assert.NoError(t, update(db))
+}
@@ -0,0 +1,316 @@
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ville-vv/gutils/uuids"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDatabasePattern = `(?i)(^|[_-])test($|[_-])`
+	timeLayout             = "2006-01-02 15:04:05"
+)
+
+var (
+	ErrUnsafeDatabase = errors.New("fixtures: database name does not match the test pattern")
+)
+
+type Option func(*Loader)
+
+// WithDir 从 fsys 的 dir 目录读取所有 .yml/.yaml/.json 文件，按文件名排序加载
+func WithDir(dir string) Option {
+	return func(l *Loader) {
+		l.dir = dir
+	}
+}
+
+// WithFiles 按给定的顺序加载文件，被引用的表需要放在前面
+func WithFiles(files ...string) Option {
+	return func(l *Loader) {
+		l.files = files
+	}
+}
+
+// WithDatabasePattern 允许加载的数据库名正则，默认要求 test 是库名中以 _ 或 - 分隔的一段，例如 test、app_test、test-orders
+func WithDatabasePattern(pattern string) Option {
+	return func(l *Loader) {
+		l.pattern = regexp.MustCompile(pattern)
+	}
+}
+
+// WithTemplateFuncs 追加模板函数
+func WithTemplateFuncs(funcs template.FuncMap) Option {
+	return func(l *Loader) {
+		for k, v := range funcs {
+			l.funcs[k] = v
+		}
+	}
+}
+
+// WithNow 固定模板中 now 的时间，方便断言
+func WithNow(now time.Time) Option {
+	return func(l *Loader) {
+		l.now = func() time.Time { return now }
+	}
+}
+
+// Loader 测试数据加载器，替代 MysqlDB.ClearAllData。
+//
+// 每个文件对应一张表，文件名（去掉扩展名）即表名。文件内容是行的列表，或者以标签为 key 的行，
+// 带标签的行可以被后面的文件引用。文件内容先按 text/template 渲染，内置函数：
+//
+//	{{ now }}                  当前时间
+//	{{ uuid }}                 随机 uuid
+//	{{ ref "users.alice.id" }} 引用已加载的 users 表中标签为 alice 的行的 id 列
+//
+// 加载时在一个事务中清空文件涉及的表并插入数据，其他表不受影响
+type Loader struct {
+	db      *gorm.DB
+	fsys    fs.FS
+	dir     string
+	files   []string
+	pattern *regexp.Regexp
+	funcs   template.FuncMap
+	now     func() time.Time
+	refs    map[string]map[string]interface{}
+}
+
+func New(db *gorm.DB, fsys fs.FS, opts ...Option) *Loader {
+	l := &Loader{
+		db:      db,
+		fsys:    fsys,
+		dir:     ".",
+		pattern: regexp.MustCompile(defaultDatabasePattern),
+		now:     time.Now,
+	}
+	l.funcs = template.FuncMap{
+		"now": func() string {
+			return l.now().Format(timeLayout)
+		},
+		"uuid": uuids.UUID,
+		"ref":  l.ref,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *Loader) ref(name string) (interface{}, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("fixtures: invalid reference %q, want table.label.column", name)
+	}
+	row, ok := l.refs[parts[0]+"."+parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("fixtures: reference %q not found, make sure the table is loaded before", name)
+	}
+	v, ok := row[parts[2]]
+	if !ok {
+		return nil, fmt.Errorf("fixtures: reference %q has no column %s", name, parts[2])
+	}
+	return v, nil
+}
+
+type fixtureRow struct {
+	label  string
+	values map[string]interface{}
+}
+
+type fixtureFile struct {
+	table string
+	rows  []fixtureRow
+}
+
+func (l *Loader) fileNames() ([]string, error) {
+	if len(l.files) > 0 {
+		return l.files, nil
+	}
+	entries, err := fs.ReadDir(l.fsys, l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch path.Ext(entry.Name()) {
+		case ".yml", ".yaml", ".json":
+			names = append(names, path.Join(l.dir, entry.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// render 渲染模板，引用只能指向前面已经加载的文件，所以每个文件在插入前才渲染
+func (l *Loader) render(name string, content []byte) ([]byte, error) {
+	tpl, err := template.New(name).Funcs(l.funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseFile 解析渲染后的文件内容，支持行列表或者 标签 -> 行 的映射
+func parseFile(name string, content []byte) (*fixtureFile, error) {
+	base := path.Base(name)
+	ff := &fixtureFile{table: strings.TrimSuffix(base, path.Ext(base))}
+	if path.Ext(name) == ".json" {
+		var list []map[string]interface{}
+		if err := json.Unmarshal(content, &list); err == nil {
+			for _, values := range list {
+				ff.rows = append(ff.rows, fixtureRow{values: values})
+			}
+			return ff, nil
+		}
+	}
+	// json 是 yaml 的子集，带标签的 json 对象也走 yaml 解析以保留顺序
+	var node yaml.Node
+	if err := yaml.Unmarshal(content, &node); err != nil {
+		return nil, fmt.Errorf("fixtures: parse %s: %w", name, err)
+	}
+	if len(node.Content) == 0 {
+		return ff, nil
+	}
+	root := node.Content[0]
+	switch root.Kind {
+	case yaml.SequenceNode:
+		for _, item := range root.Content {
+			values := make(map[string]interface{})
+			if err := item.Decode(&values); err != nil {
+				return nil, fmt.Errorf("fixtures: parse %s: %w", name, err)
+			}
+			ff.rows = append(ff.rows, fixtureRow{values: values})
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			values := make(map[string]interface{})
+			if err := root.Content[i+1].Decode(&values); err != nil {
+				return nil, fmt.Errorf("fixtures: parse %s: %w", name, err)
+			}
+			ff.rows = append(ff.rows, fixtureRow{label: root.Content[i].Value, values: values})
+		}
+	default:
+		return nil, fmt.Errorf("fixtures: %s must be a list or a mapping of rows", name)
+	}
+	return ff, nil
+}
+
+// guard 只允许在库名匹配测试规则的数据库上执行
+func (l *Loader) guard(ctx context.Context) error {
+	var name string
+	if err := l.db.WithContext(ctx).Raw("SELECT DATABASE()").Scan(&name).Error; err != nil {
+		return err
+	}
+	if !l.pattern.MatchString(name) {
+		return fmt.Errorf("%w: %q !~ %s", ErrUnsafeDatabase, name, l.pattern.String())
+	}
+	return nil
+}
+
+// Load 检查数据库名后，在一个事务中清空文件对应的表并插入数据
+func (l *Loader) Load(ctx context.Context) error {
+	if err := l.guard(ctx); err != nil {
+		return err
+	}
+	names, err := l.fileNames()
+	if err != nil {
+		return err
+	}
+	l.refs = make(map[string]map[string]interface{})
+	return l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET FOREIGN_KEY_CHECKS = 0").Error; err != nil {
+			return err
+		}
+		defer tx.Exec("SET FOREIGN_KEY_CHECKS = 1")
+		// 先清空所有表，避免后面的文件引用到被清空的数据
+		tables := make(map[string]bool)
+		for _, name := range names {
+			base := path.Base(name)
+			table := strings.TrimSuffix(base, path.Ext(base))
+			if tables[table] {
+				continue
+			}
+			tables[table] = true
+			if err := tx.Exec(fmt.Sprintf("DELETE FROM `%s`", table)).Error; err != nil {
+				return err
+			}
+		}
+		for _, name := range names {
+			if err := l.loadFile(ctx, tx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (l *Loader) loadFile(ctx context.Context, tx *gorm.DB, name string) error {
+	content, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return err
+	}
+	if content, err = l.render(name, content); err != nil {
+		return fmt.Errorf("fixtures: render %s: %w", name, err)
+	}
+	ff, err := parseFile(name, content)
+	if err != nil {
+		return err
+	}
+	for _, row := range ff.rows {
+		if err = l.insert(ctx, tx, ff.table, row); err != nil {
+			return fmt.Errorf("fixtures: insert into %s: %w", ff.table, err)
+		}
+	}
+	return nil
+}
+
+func (l *Loader) insert(ctx context.Context, tx *gorm.DB, table string, row fixtureRow) error {
+	columns := make([]string, 0, len(row.values))
+	for col := range row.values {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	args := make([]interface{}, 0, len(columns))
+	quoted := make([]string, 0, len(columns))
+	for _, col := range columns {
+		quoted = append(quoted, "`"+col+"`")
+		args = append(args, row.values[col])
+	}
+	stmt := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)", table,
+		strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	res, err := tx.Statement.ConnPool.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	if row.label == "" {
+		return nil
+	}
+	// 没有显式指定 id 时记录自增 id，便于后续文件引用
+	if _, ok := row.values["id"]; !ok {
+		if id, err := res.LastInsertId(); err == nil && id > 0 {
+			row.values["id"] = id
+		}
+	}
+	l.refs[table+"."+row.label] = row.values
+	return nil
+}
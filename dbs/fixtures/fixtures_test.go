@@ -0,0 +1,66 @@
+package fixtures
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFile(t *testing.T) {
+	ff, err := parseFile("testdata/users.yml", []byte(`
+alice:
+  name: alice
+  age: 18
+bob:
+  name: bob
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "users", ff.table)
+	if assert.Len(t, ff.rows, 2) {
+		assert.Equal(t, "alice", ff.rows[0].label)
+		assert.Equal(t, 18, ff.rows[0].values["age"])
+		assert.Equal(t, "bob", ff.rows[1].label)
+	}
+
+	ff, err = parseFile("orders.json", []byte(`[{"id": 1, "user_id": 2}, {"id": 2}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, "orders", ff.table)
+	assert.Len(t, ff.rows, 2)
+	assert.Equal(t, "", ff.rows[0].label)
+
+	_, err = parseFile("bad.yml", []byte(`just a string`))
+	assert.Error(t, err)
+}
+
+func TestLoader_render(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	l := New(nil, nil, WithNow(now))
+	l.refs = map[string]map[string]interface{}{
+		"users.alice": {"id": int64(7)},
+	}
+	out, err := l.render("orders.yml", []byte(`- user_id: {{ ref "users.alice.id" }}
+  created_at: "{{ now }}"
+  no: "{{ uuid }}"`))
+	assert.NoError(t, err)
+	ff, err := parseFile("orders.yml", out)
+	assert.NoError(t, err)
+	if assert.Len(t, ff.rows, 1) {
+		assert.Equal(t, 7, ff.rows[0].values["user_id"])
+		assert.Equal(t, "2024-01-02 03:04:05", ff.rows[0].values["created_at"])
+		assert.Len(t, ff.rows[0].values["no"], 36)
+	}
+
+	_, err = l.render("orders.yml", []byte(`- user_id: {{ ref "users.bob.id" }}`))
+	assert.Error(t, err)
+}
+
+func TestDefaultDatabasePattern(t *testing.T) {
+	l := New(nil, nil)
+	for _, name := range []string{"test", "TEST", "app_test", "test_app", "app-test-1", "app_test_orders"} {
+		assert.True(t, l.pattern.MatchString(name), name)
+	}
+	for _, name := range []string{"latest", "attestation", "prod_latest", "testing", "contest_db", "prod"} {
+		assert.False(t, l.pattern.MatchString(name), name)
+	}
+}
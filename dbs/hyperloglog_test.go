@@ -0,0 +1,54 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniqueCounterKeys(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	c := &UniqueCounter{name: "uv", bucket: BucketDaily, loc: loc}
+	from := time.Date(2024, 1, 30, 23, 0, 0, 0, loc)
+	assert.Equal(t, "{uv}:20240130", c.Key(from))
+	assert.Equal(t, []string{"{uv}:20240130", "{uv}:20240131", "{uv}:20240201"}, c.keys(from, from.Add(48*time.Hour)))
+
+	c.bucket = BucketHourly
+	assert.Equal(t, []string{"{uv}:2024013023", "{uv}:2024013100"}, c.keys(from, from.Add(90*time.Minute)))
+
+	c.bucket = BucketWeekly
+	assert.Equal(t, "{uv}:2024W05", c.Key(from))
+	assert.Equal(t, []string{"{uv}:2024W05", "{uv}:2024W06"}, c.keys(from, from.AddDate(0, 0, 6)))
+}
+
+func TestUniqueCounterRedis(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	c := NewUniqueCounter(rds, "uv", BucketDaily, WithCounterLocation(time.UTC), WithCounterTTL(time.Hour))
+	day := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC)
+
+	changed, err := c.Add(ctx, day, "u1", "u2")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = c.Add(ctx, day, "u1")
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, err = c.Add(ctx, day.AddDate(0, 0, 1), "u2", "u3")
+	require.NoError(t, err)
+
+	n, err := c.Count(ctx, day)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	// 部分 redis 替身（如 miniredis）的多 key PFCOUNT 是各 key 计数之和而不是并集，跨桶去重通过 MergeRange 验证
+	n, err = c.CountRange(ctx, day, day)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	require.NoError(t, c.MergeRange(ctx, "{uv}:week", day, day.AddDate(0, 0, 1), time.Hour))
+	n, err = rds.rds.PFCount(ctx, "{uv}:week").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
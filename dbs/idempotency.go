@@ -0,0 +1,147 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ville-vv/gutils/uuids"
+)
+
+var (
+	ErrIdempotencyConflict   = errors.New("idempotency: key reused with a different request")
+	ErrIdempotencyInProgress = errors.New("idempotency: request with the same key is in progress")
+	ErrIdempotencyLost       = errors.New("idempotency: in-progress record expired or taken over")
+)
+
+type IdempotencyState string
+
+const (
+	IdempotencyInProgress IdempotencyState = "in_progress"
+	IdempotencyCompleted  IdempotencyState = "completed"
+)
+
+// KEYS[1] 记录 hash ARGV[1] fingerprint ARGV[2] token ARGV[3] 处理中状态的租约(ms)
+var idempotencyBeginScript = redis.NewScript(`
+local cur = redis.call("HMGET", KEYS[1], "fp", "state", "resp")
+if cur[1] == false then
+	redis.call("HSET", KEYS[1], "fp", ARGV[1], "state", "in_progress", "token", ARGV[2])
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+	return {"new"}
+end
+if cur[1] ~= ARGV[1] then
+	return {"conflict"}
+end
+if cur[2] ~= "completed" then
+	return {"in_progress"}
+end
+return {"completed", cur[3]}
+`)
+
+// KEYS[1] 记录 hash ARGV[1] token ARGV[2] 响应 ARGV[3] 保留时间(ms)
+var idempotencyCompleteScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "token") ~= ARGV[1] or redis.call("HGET", KEYS[1], "state") ~= "in_progress" then
+	return 0
+end
+redis.call("HSET", KEYS[1], "state", "completed", "resp", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// KEYS[1] 记录 hash ARGV[1] token
+var idempotencyReleaseScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "token") == ARGV[1] and redis.call("HGET", KEYS[1], "state") == "in_progress" then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type IdempotencyOption func(i *Idempotency)
+
+// WithIdempotencyTTL 完成后的响应保留时间，默认 24h
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *Idempotency) {
+		i.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTTL 处理中状态的租约，超过后认为处理方已崩溃，同一个 key 可以重新开始，默认 1 分钟
+func WithIdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *Idempotency) {
+		i.lockTTL = ttl
+	}
+}
+
+// IdempotencyRecord 一次请求的幂等记录，State 为 IdempotencyCompleted 时 Response 为保存的响应
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	State       IdempotencyState
+	Response    []byte
+	token       string
+}
+
+// Idempotency 基于 redis 的幂等键存储。同一个 key 第一次 Begin 时记录 fingerprint 并进入处理中状态，
+// 处理完成后 Complete 保存响应，之后相同 key 和 fingerprint 的请求直接拿到保存的响应；
+// fingerprint 不同返回 ErrIdempotencyConflict，仍在处理中返回 ErrIdempotencyInProgress
+type Idempotency struct {
+	rds     redis.Cmdable
+	name    string
+	ttl     time.Duration
+	lockTTL time.Duration
+}
+
+func NewIdempotency(rds *RedisDB, name string, opts ...IdempotencyOption) *Idempotency {
+	i := &Idempotency{rds: rds.rds, name: rds.Key(name), ttl: 24 * time.Hour, lockTTL: time.Minute}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+func (i *Idempotency) recordKey(key string) string {
+	return i.name + ":" + key
+}
+
+// Begin 开始处理 key 对应的请求。返回的记录为 IdempotencyInProgress 时由调用方处理，
+// 之后必须调用 Complete 或 Release；为 IdempotencyCompleted 时直接使用 Response
+func (i *Idempotency) Begin(ctx context.Context, key, fingerprint string) (*IdempotencyRecord, error) {
+	rec := &IdempotencyRecord{Key: key, Fingerprint: fingerprint, token: uuids.UUID()}
+	res, err := idempotencyBeginScript.Run(ctx, i.rds, []string{i.recordKey(key)}, fingerprint, rec.token, i.lockTTL.Milliseconds()).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	switch res[0] {
+	case "new":
+		rec.State = IdempotencyInProgress
+		return rec, nil
+	case "conflict":
+		return nil, ErrIdempotencyConflict
+	case "in_progress":
+		return nil, ErrIdempotencyInProgress
+	}
+	rec.State, rec.token = IdempotencyCompleted, ""
+	if len(res) > 1 {
+		rec.Response = []byte(res[1])
+	}
+	return rec, nil
+}
+
+// Complete 保存响应并标记完成，租约已过期或被其他请求接管时返回 ErrIdempotencyLost
+func (i *Idempotency) Complete(ctx context.Context, rec *IdempotencyRecord, response []byte) error {
+	ok, err := idempotencyCompleteScript.Run(ctx, i.rds, []string{i.recordKey(rec.Key)}, rec.token, response, i.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrIdempotencyLost
+	}
+	rec.State, rec.Response = IdempotencyCompleted, response
+	return nil
+}
+
+// Release 处理失败时删除处理中的记录，让客户端可以用同一个 key 重试
+func (i *Idempotency) Release(ctx context.Context, rec *IdempotencyRecord) error {
+	return idempotencyReleaseScript.Run(ctx, i.rds, []string{i.recordKey(rec.Key)}, rec.token).Err()
+}
@@ -0,0 +1,222 @@
+package dbs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ville-vv/gutils/zlog"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+type idempotencyHTTPOptions struct {
+	header   string
+	methods  map[string]bool
+	required bool
+	maxBody  int64
+	scope    func(r *http.Request) string
+	fpHeader []string
+}
+
+type IdempotencyHTTPOption func(o *idempotencyHTTPOptions)
+
+// WithIdempotencyHeader 携带幂等键的请求头，默认 Idempotency-Key
+func WithIdempotencyHeader(header string) IdempotencyHTTPOption {
+	return func(o *idempotencyHTTPOptions) {
+		o.header = header
+	}
+}
+
+// WithIdempotencyMethods 需要幂等处理的方法，默认只有 POST
+func WithIdempotencyMethods(methods ...string) IdempotencyHTTPOption {
+	return func(o *idempotencyHTTPOptions) {
+		o.methods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			o.methods[strings.ToUpper(m)] = true
+		}
+	}
+}
+
+// WithIdempotencyRequired 缺少幂等键时返回 400，默认直接放行
+func WithIdempotencyRequired(required bool) IdempotencyHTTPOption {
+	return func(o *idempotencyHTTPOptions) {
+		o.required = required
+	}
+}
+
+// WithIdempotencyMaxBody 参与 fingerprint 计算的请求体上限，超过时返回 413，默认 1MB
+func WithIdempotencyMaxBody(n int64) IdempotencyHTTPOption {
+	return func(o *idempotencyHTTPOptions) {
+		o.maxBody = n
+	}
+}
+
+// WithIdempotencyScope 按调用方隔离幂等键，fn 通常返回用户或租户 id，
+// 不同调用方使用相同的 key 互不影响；返回空字符串时不隔离
+func WithIdempotencyScope(fn func(r *http.Request) string) IdempotencyHTTPOption {
+	return func(o *idempotencyHTTPOptions) {
+		o.scope = fn
+	}
+}
+
+// WithIdempotencyFingerprintHeaders 参与 fingerprint 计算的请求头，默认只有 Authorization，
+// 其他调用方拿到同一个 key 时因为 fingerprint 不同返回 422，而不是回放别人的响应
+func WithIdempotencyFingerprintHeaders(headers ...string) IdempotencyHTTPOption {
+	return func(o *idempotencyHTTPOptions) {
+		o.fpHeader = headers
+	}
+}
+
+// storedResponse 保存到 redis 的 http 响应
+type storedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// responseRecorder 把响应同时写给客户端和缓冲区
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// requestFingerprint 方法、路径、查询参数、指定请求头和请求体的 sha256
+func requestFingerprint(r *http.Request, headers []string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	for _, name := range headers {
+		h.Write([]byte(name + ": " + strings.Join(r.Header.Values(name), ",") + "\n"))
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Middleware net/http 中间件，让携带幂等键的 POST 请求可以安全重试，可以直接包装 http.Handler 使用。
+// 首次请求正常处理并保存响应（5xx 和 panic 不保存，允许重试），重复请求回放保存的响应并带上 Idempotent-Replayed 头；
+// 同一个 key 用于不同请求返回 422，上一次请求仍在处理中返回 409。redis 不可用时直接放行
+func (i *Idempotency) Middleware(opts ...IdempotencyHTTPOption) func(http.Handler) http.Handler {
+	o := &idempotencyHTTPOptions{
+		header:   IdempotencyKeyHeader,
+		methods:  map[string]bool{http.MethodPost: true},
+		maxBody:  1 << 20,
+		fpHeader: []string{"Authorization"},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !o.methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(o.header)
+			if key == "" {
+				if o.required {
+					http.Error(w, "missing "+o.header+" header", http.StatusBadRequest)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, o.maxBody+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > o.maxBody {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if o.scope != nil {
+				if scope := o.scope(r); scope != "" {
+					key = scope + ":" + key
+				}
+			}
+
+			ctx := r.Context()
+			rec, err := i.Begin(ctx, key, requestFingerprint(r, o.fpHeader, body))
+			switch {
+			case errors.Is(err, ErrIdempotencyConflict):
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			case errors.Is(err, ErrIdempotencyInProgress):
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				zlog.WithContext(ctx).Warnf("idempotency: begin %s: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if rec.State == IdempotencyCompleted {
+				var resp storedResponse
+				if err = json.Unmarshal(rec.Response, &resp); err != nil {
+					http.Error(w, "idempotency: broken stored response", http.StatusInternalServerError)
+					return
+				}
+				for k, v := range resp.Header {
+					w.Header()[k] = v
+				}
+				w.Header().Set(IdempotencyReplayedHeader, "true")
+				w.WriteHeader(resp.Status)
+				_, _ = w.Write(resp.Body)
+				return
+			}
+
+			rw := &responseRecorder{ResponseWriter: w}
+			completed := false
+			defer func() {
+				if !completed {
+					// 出错或 panic 时释放，客户端可以用同一个 key 重试
+					if err := i.Release(context.WithoutCancel(ctx), rec); err != nil {
+						zlog.WithContext(ctx).Warnf("idempotency: release %s: %v", key, err)
+					}
+				}
+			}()
+			next.ServeHTTP(rw, r)
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+			if rw.status >= http.StatusInternalServerError {
+				return
+			}
+			data, err := json.Marshal(storedResponse{Status: rw.status, Header: w.Header().Clone(), Body: rw.body.Bytes()})
+			if err != nil {
+				return
+			}
+			if err = i.Complete(context.WithoutCancel(ctx), rec, data); err != nil {
+				zlog.WithContext(ctx).Warnf("idempotency: complete %s: %v", key, err)
+				return
+			}
+			completed = true
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package dbs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/httpc"
+)
+
+func TestIdempotency(t *testing.T) {
+	srv, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	idem := NewIdempotency(rds, "idem", WithIdempotencyTTL(time.Hour), WithIdempotencyLockTTL(time.Second))
+
+	rec, err := idem.Begin(ctx, "k1", "fp")
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyInProgress, rec.State)
+
+	_, err = idem.Begin(ctx, "k1", "fp")
+	assert.ErrorIs(t, err, ErrIdempotencyInProgress)
+	_, err = idem.Begin(ctx, "k1", "other")
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	require.NoError(t, idem.Complete(ctx, rec, []byte("done")))
+	replay, err := idem.Begin(ctx, "k1", "fp")
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyCompleted, replay.State)
+	assert.Equal(t, []byte("done"), replay.Response)
+
+	// 释放后可以重新开始
+	rec, err = idem.Begin(ctx, "k2", "fp")
+	require.NoError(t, err)
+	require.NoError(t, idem.Release(ctx, rec))
+	rec, err = idem.Begin(ctx, "k2", "fp")
+	require.NoError(t, err)
+
+	// 租约过期后被其他请求接管，原处理方不能再完成
+	srv.FastForward(2 * time.Second)
+	taken, err := idem.Begin(ctx, "k2", "fp")
+	require.NoError(t, err)
+	assert.ErrorIs(t, idem.Complete(ctx, rec, []byte("late")), ErrIdempotencyLost)
+	require.NoError(t, idem.Complete(ctx, taken, []byte("ok")))
+
+	srv.FastForward(2 * time.Hour)
+	rec, err = idem.Begin(ctx, "k1", "other")
+	require.NoError(t, err)
+	assert.Equal(t, IdempotencyInProgress, rec.State)
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	idem := NewIdempotency(rds, "orders")
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if strings.Contains(r.URL.Path, "fail") {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"order":%d}`, n)
+	})
+	ts := httptest.NewServer(idem.Middleware()(handler))
+	defer ts.Close()
+
+	head := map[string]string{IdempotencyKeyHeader: "req-1"}
+	body, err := httpc.Do().PostForJson(ts.URL+"/orders", map[string]int{"amount": 10}, head)
+	require.NoError(t, err)
+	assert.Equal(t, `{"order":1}`, string(body))
+	body, err = httpc.Do().PostForJson(ts.URL+"/orders", map[string]int{"amount": 10}, head)
+	require.NoError(t, err)
+	assert.Equal(t, `{"order":1}`, string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	post := func(path, key, payload string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(payload))
+		require.NoError(t, err)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp := post("/orders", "req-1", `{"amount":10}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(IdempotencyReplayedHeader))
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	resp = post("/orders", "req-1", `{"amount":11}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// 5xx 不保存，同一个 key 可以重试
+	assert.Equal(t, http.StatusInternalServerError, post("/fail", "req-2", "").StatusCode)
+	assert.Equal(t, http.StatusInternalServerError, post("/fail", "req-2", "").StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// 没有幂等键时直接放行
+	post("/orders", "", "")
+	post("/orders", "", "")
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyMiddleware_Principal(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s:%d", r.Header.Get("Authorization"), atomic.AddInt32(&calls, 1))
+	})
+	post := func(ts *httptest.Server, auth, tenant string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/orders", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		req.Header.Set(IdempotencyKeyHeader, "req-1")
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("X-Tenant", tenant)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	// 默认 Authorization 参与 fingerprint，其他调用方不会拿到别人的响应
+	ts := httptest.NewServer(NewIdempotency(rds, "fp").Middleware()(handler))
+	defer ts.Close()
+	code, body := post(ts, "alice", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alice:1", body)
+	code, _ = post(ts, "bob", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	_, body = post(ts, "alice", "")
+	assert.Equal(t, "alice:1", body)
+
+	// 按租户隔离后，不同租户使用相同的 key 各自处理
+	scoped := httptest.NewServer(NewIdempotency(rds, "scoped").Middleware(
+		WithIdempotencyScope(func(r *http.Request) string { return r.Header.Get("X-Tenant") }),
+		WithIdempotencyFingerprintHeaders(),
+	)(handler))
+	defer scoped.Close()
+	_, body = post(scoped, "alice", "t1")
+	assert.Equal(t, "alice:2", body)
+	_, body = post(scoped, "bob", "t2")
+	assert.Equal(t, "bob:3", body)
+	_, body = post(scoped, "carol", "t1")
+	assert.Equal(t, "alice:2", body)
+}
@@ -0,0 +1,342 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	leaderboardAllTimeSpan = 1 << 31 // 不分桶时 tie-break 的时间范围（秒），约 68 年
+	leaderboardMaxMerge    = 1024    // 分桶时最多合并的桶数
+)
+
+var (
+	ErrNotRanked           = errors.New("leaderboard: member not ranked")
+	ErrLeaderboardReadOnly = errors.New("leaderboard: merged view is read only")
+)
+
+// leaderboardIncrScript 读出积分加上增量，再用新的时间写回，保证并发下积分不丢失。
+// 分数超过 14 位时 lua 默认的数字转字符串会丢精度，所以用 %.0f 格式化
+var leaderboardIncrScript = redis.NewScript(`
+local unit = tonumber(ARGV[3])
+local cur = redis.call("ZSCORE", KEYS[1], ARGV[1])
+local points = 0
+if cur then
+	points = math.floor(tonumber(cur) / unit)
+end
+points = points + tonumber(ARGV[2])
+redis.call("ZADD", KEYS[1], string.format("%.0f", points * unit + tonumber(ARGV[4])), ARGV[1])
+if tonumber(ARGV[5]) > 0 then
+	redis.call("PEXPIREAT", KEYS[1], ARGV[5])
+end
+return string.format("%.0f", points)
+`)
+
+type LeaderboardEntry struct {
+	Member string
+	Score  int64
+	Rank   int64 // 从 1 开始
+}
+
+type LeaderboardOption func(l *Leaderboard)
+
+// WithLeaderboardBucket 按时间分桶（日榜、周榜等），每个桶在结束 retain 之后过期，retain 默认为一个桶的长度
+func WithLeaderboardBucket(bucket TimeBucket, retain time.Duration) LeaderboardOption {
+	return func(l *Leaderboard) {
+		l.bucketed = true
+		l.bucket = bucket
+		l.retain = retain
+	}
+}
+
+// WithLeaderboardTieBreak 积分相同时先达到的排在前面，精度为秒。
+// 不分桶时以 epoch 为起点，积分绝对值需小于 2^22；分桶时以桶的开始时间为起点
+func WithLeaderboardTieBreak(epoch time.Time) LeaderboardOption {
+	return func(l *Leaderboard) {
+		l.tieBreak = true
+		l.epoch = epoch
+	}
+}
+
+// WithLeaderboardLocation 划分时间桶使用的时区，默认 time.Local
+func WithLeaderboardLocation(loc *time.Location) LeaderboardOption {
+	return func(l *Leaderboard) {
+		l.loc = loc
+	}
+}
+
+// Leaderboard 基于 sorted set 的排行榜，分数从高到低排名。
+// 默认使用当前时间所在的桶，At 返回指定时间所在桶的视图，Merge 返回多个桶合并后的只读视图。
+// 所有 key 以 {name} 为 hash tag，cluster 下可以直接 ZUNIONSTORE
+type Leaderboard struct {
+	rds      redis.Cmdable
+	name     string
+	bucketed bool
+	bucket   TimeBucket
+	retain   time.Duration
+	tieBreak bool
+	epoch    time.Time
+	loc      *time.Location
+	now      func() time.Time
+	at       time.Time // 非零时固定使用该时间所在的桶
+	merged   string    // 合并视图的 key
+}
+
+func NewLeaderboard(rds *RedisDB, name string, opts ...LeaderboardOption) *Leaderboard {
+	l := &Leaderboard{rds: rds.rds, name: rds.Key(name), loc: time.Local, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.bucketed && l.retain <= 0 {
+		l.retain = l.bucket.span()
+	}
+	return l
+}
+
+// At 返回 t 所在时间桶的排行榜，未分桶时返回自身
+func (l *Leaderboard) At(t time.Time) *Leaderboard {
+	if !l.bucketed {
+		return l
+	}
+	v := *l
+	v.at = t
+	v.merged = ""
+	return &v
+}
+
+// Key 当前视图对应的 redis key
+func (l *Leaderboard) Key() string {
+	if l.merged != "" {
+		return l.merged
+	}
+	if !l.bucketed {
+		return "{" + l.name + "}"
+	}
+	return l.bucketKey(l.bucketStart())
+}
+
+func (l *Leaderboard) bucketKey(start time.Time) string {
+	return "{" + l.name + "}:" + l.bucket.key(start)
+}
+
+func (l *Leaderboard) bucketStart() time.Time {
+	t := l.at
+	if t.IsZero() {
+		t = l.now()
+	}
+	return l.bucket.start(t.In(l.loc))
+}
+
+// tieSpan tie-break 占用的时间范围（秒），DST 时一天可能是 25 小时，多留一小时
+func (l *Leaderboard) tieSpan() int64 {
+	if !l.tieBreak {
+		return 1
+	}
+	if !l.bucketed {
+		return leaderboardAllTimeSpan
+	}
+	return int64((l.bucket.span() + time.Hour) / time.Second)
+}
+
+// unit 一分对应的 zset 分数，分桶时预留合并 leaderboardMaxMerge 个桶后 tie 部分相加不会进位
+func (l *Leaderboard) unit() int64 {
+	if l.tieBreak && l.bucketed {
+		return l.tieSpan() * leaderboardMaxMerge
+	}
+	return l.tieSpan()
+}
+
+// tie 越早达到越大
+func (l *Leaderboard) tie(now time.Time) int64 {
+	if !l.tieBreak {
+		return 0
+	}
+	base := l.epoch
+	if l.bucketed {
+		base = l.bucketStart()
+	}
+	span := l.tieSpan()
+	elapsed := now.Unix() - base.Unix()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed > span-1 {
+		elapsed = span - 1
+	}
+	return span - 1 - elapsed
+}
+
+func (l *Leaderboard) decode(score float64) int64 {
+	return int64(math.Floor(score / float64(l.unit())))
+}
+
+// expireAt 分桶时桶结束 retain 之后过期，不分桶时返回零值
+func (l *Leaderboard) expireAt() time.Time {
+	if !l.bucketed {
+		return time.Time{}
+	}
+	return l.bucket.next(l.bucketStart()).Add(l.retain)
+}
+
+// Incr 给 member 增加积分，返回新的积分
+func (l *Leaderboard) Incr(ctx context.Context, member string, delta int64) (int64, error) {
+	if l.merged != "" {
+		return 0, ErrLeaderboardReadOnly
+	}
+	key, exp := l.Key(), l.expireAt()
+	if l.tieBreak {
+		var expMs int64
+		if !exp.IsZero() {
+			expMs = exp.UnixMilli()
+		}
+		return leaderboardIncrScript.Run(ctx, l.rds, []string{key}, member, delta, l.unit(), l.tie(l.now()), expMs).Int64()
+	}
+	var incr *redis.FloatCmd
+	_, err := l.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.ZIncrBy(ctx, key, float64(delta), member)
+		if !exp.IsZero() {
+			pipe.ExpireAt(ctx, key, exp)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return l.decode(incr.Val()), nil
+}
+
+// Set 直接设置 member 的积分
+func (l *Leaderboard) Set(ctx context.Context, member string, score int64) error {
+	if l.merged != "" {
+		return ErrLeaderboardReadOnly
+	}
+	key, exp := l.Key(), l.expireAt()
+	_, err := l.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(score*l.unit() + l.tie(l.now())), Member: member})
+		if !exp.IsZero() {
+			pipe.ExpireAt(ctx, key, exp)
+		}
+		return nil
+	})
+	return err
+}
+
+// Remove 从排行榜中移除
+func (l *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	if l.merged != "" {
+		return ErrLeaderboardReadOnly
+	}
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return l.rds.ZRem(ctx, l.Key(), args...).Err()
+}
+
+// Get 返回 member 的积分和排名，不在榜上时返回 ErrNotRanked
+func (l *Leaderboard) Get(ctx context.Context, member string) (*LeaderboardEntry, error) {
+	var (
+		rank  *redis.IntCmd
+		score *redis.FloatCmd
+	)
+	_, err := l.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		rank = pipe.ZRevRank(ctx, l.Key(), member)
+		score = pipe.ZScore(ctx, l.Key(), member)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotRanked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &LeaderboardEntry{Member: member, Score: l.decode(score.Val()), Rank: rank.Val() + 1}, nil
+}
+
+// Count 榜上的成员数
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	return l.rds.ZCard(ctx, l.Key()).Result()
+}
+
+// Top 前 n 名
+func (l *Leaderboard) Top(ctx context.Context, n int64) ([]LeaderboardEntry, error) {
+	return l.rangeByRank(ctx, 0, n-1)
+}
+
+// Page 分页查询，page 从 1 开始
+func (l *Leaderboard) Page(ctx context.Context, page, size int64) ([]LeaderboardEntry, error) {
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * size
+	return l.rangeByRank(ctx, start, start+size-1)
+}
+
+// Around 返回 member 以及排在它前后各 n 名
+func (l *Leaderboard) Around(ctx context.Context, member string, n int64) ([]LeaderboardEntry, error) {
+	rank, err := l.rds.ZRevRank(ctx, l.Key(), member).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotRanked
+	}
+	if err != nil {
+		return nil, err
+	}
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+	return l.rangeByRank(ctx, start, rank+n)
+}
+
+func (l *Leaderboard) rangeByRank(ctx context.Context, start, stop int64) ([]LeaderboardEntry, error) {
+	if stop < start {
+		return nil, nil
+	}
+	zs, err := l.rds.ZRevRangeWithScores(ctx, l.Key(), start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LeaderboardEntry, len(zs))
+	for i, z := range zs {
+		member, _ := z.Member.(string)
+		entries[i] = LeaderboardEntry{Member: member, Score: l.decode(z.Score), Rank: start + int64(i) + 1}
+	}
+	return entries, nil
+}
+
+// Merge 用 ZUNIONSTORE 把 [from, to] 覆盖的桶的积分相加，返回合并结果的只读视图，结果保留 ttl。
+// 开启 tie-break 时合并后积分相同的按各桶中达到的时间之和排序
+func (l *Leaderboard) Merge(ctx context.Context, from, to time.Time, ttl time.Duration) (*Leaderboard, error) {
+	if !l.bucketed {
+		return nil, fmt.Errorf("leaderboard %s: merge requires a bucketed leaderboard", l.name)
+	}
+	var keys []string
+	for t := l.bucket.start(from.In(l.loc)); !t.After(to.In(l.loc)); t = l.bucket.next(t) {
+		keys = append(keys, l.bucketKey(t))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("leaderboard %s: empty merge range", l.name)
+	}
+	if l.tieBreak && len(keys) > leaderboardMaxMerge {
+		return nil, fmt.Errorf("leaderboard %s: cannot merge more than %d buckets", l.name, leaderboardMaxMerge)
+	}
+	dest := "{" + l.name + "}:merge:" + l.bucket.key(l.bucket.start(from.In(l.loc))) + "-" + l.bucket.key(l.bucket.start(to.In(l.loc)))
+	_, err := l.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(ctx, dest, &redis.ZStore{Keys: keys, Aggregate: "SUM"})
+		if ttl > 0 {
+			pipe.Expire(ctx, dest, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := *l
+	v.merged = dest
+	return &v, nil
+}
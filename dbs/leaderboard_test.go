@@ -0,0 +1,88 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderboardKey(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	now := time.Date(2024, 3, 6, 10, 30, 0, 0, loc)
+	l := &Leaderboard{name: "game", loc: loc, now: func() time.Time { return now }}
+	assert.Equal(t, "{game}", l.Key())
+	assert.True(t, l.expireAt().IsZero())
+
+	WithLeaderboardBucket(BucketDaily, 0)(l)
+	l.retain = l.bucket.span()
+	assert.Equal(t, "{game}:20240306", l.Key())
+	assert.Equal(t, "{game}:20240301", l.At(now.AddDate(0, 0, -5)).Key())
+	assert.Equal(t, time.Date(2024, 3, 8, 0, 0, 0, 0, loc), l.expireAt())
+}
+
+func TestLeaderboardTieBreak(t *testing.T) {
+	loc := time.UTC
+	now := time.Date(2024, 3, 6, 0, 0, 0, 0, loc)
+	l := &Leaderboard{name: "game", loc: loc, now: func() time.Time { return now }}
+	assert.Equal(t, int64(1), l.unit())
+	assert.Equal(t, int64(0), l.tie(now))
+
+	WithLeaderboardTieBreak(now)(l)
+	early, late := l.tie(now), l.tie(now.Add(time.Minute))
+	assert.Greater(t, early, late)
+	score := float64(100*l.unit() + late)
+	assert.Equal(t, int64(100), l.decode(score))
+	assert.Equal(t, int64(-3), l.decode(float64(-3*l.unit()+early)))
+
+	WithLeaderboardBucket(BucketWeekly, 0)(l)
+	unit := l.unit()
+	assert.Equal(t, int64((7*24+1)*3600*leaderboardMaxMerge), unit)
+	// 合并满 leaderboardMaxMerge 个桶时 tie 部分之和不会进位
+	sum := float64(leaderboardMaxMerge * (5*unit + l.tieSpan() - 1))
+	assert.Equal(t, int64(5*leaderboardMaxMerge), l.decode(sum))
+	assert.Less(t, float64(1<<22*unit), float64(1<<53))
+}
+
+func TestLeaderboardRedis(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	// 桶的过期时间按真实时间计算，这里只固定在当天的某个时刻
+	now := time.Now().UTC().Truncate(24 * time.Hour).Add(10 * time.Hour)
+
+	l := NewLeaderboard(rds, "game", WithLeaderboardBucket(BucketDaily, 0), WithLeaderboardTieBreak(now.AddDate(0, -1, 0)), WithLeaderboardLocation(time.UTC))
+	l.now = func() time.Time { return now }
+
+	_, err := l.Incr(ctx, "alice", 10)
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = l.Incr(ctx, "bob", 10)
+	require.NoError(t, err)
+	score, err := l.Incr(ctx, "carol", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), score)
+
+	// 同分时先达到的排在前面
+	top, err := l.Top(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []LeaderboardEntry{{"alice", 10, 1}, {"bob", 10, 2}, {"carol", 5, 3}}, top)
+
+	e, err := l.Get(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, &LeaderboardEntry{"bob", 10, 2}, e)
+	_, err = l.Get(ctx, "nobody")
+	assert.ErrorIs(t, err, ErrNotRanked)
+
+	now = now.AddDate(0, 0, 1)
+	_, err = l.Incr(ctx, "carol", 20)
+	require.NoError(t, err)
+	merged, err := l.Merge(ctx, now.AddDate(0, 0, -1), now, time.Hour)
+	require.NoError(t, err)
+	top, err = merged.Top(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []LeaderboardEntry{{"carol", 25, 1}}, top)
+	_, err = merged.Incr(ctx, "carol", 1)
+	assert.ErrorIs(t, err, ErrLeaderboardReadOnly)
+}
@@ -0,0 +1,453 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ville-vv/gutils/dbs"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSchemaTable = "schema_migrations"
+	mainDBName         = "main"
+)
+
+var (
+	ErrDirty            = errors.New("migrate: database is dirty, fix it manually and run force")
+	ErrChecksumMismatch = errors.New("migrate: applied migration has been modified")
+	ErrLockTimeout      = errors.New("migrate: timeout waiting for migration lock")
+	ErrNoDownMigration  = errors.New("migrate: down migration not found")
+)
+
+type Logger interface {
+	Infof(format string, args ...interface{})
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s *stdLogger) Infof(format string, args ...interface{}) {
+	s.l.Printf(format, args...)
+}
+
+type Option func(*Migrator)
+
+// WithSchemaTable 版本记录表名，默认 schema_migrations
+func WithSchemaTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// WithDryRun 只打印将要执行的 SQL，不修改数据库
+func WithDryRun() Option {
+	return func(m *Migrator) {
+		m.dryRun = true
+	}
+}
+
+// WithLogger 日志输出，默认输出到标准输出
+func WithLogger(l Logger) Option {
+	return func(m *Migrator) {
+		m.log = l
+	}
+}
+
+// WithLockTimeout 等待迁移锁的最长时间，GET_LOCK 按秒计时，不足 1 秒的部分向上取整
+func WithLockTimeout(d time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = d
+	}
+}
+
+// Migrator 对单个数据库执行版本化的 SQL 迁移
+type Migrator struct {
+	name        string
+	db          *gorm.DB
+	fsys        fs.FS
+	dir         string
+	table       string
+	dryRun      bool
+	lockTimeout time.Duration
+	log         Logger
+}
+
+// New 创建迁移器，迁移文件从 fsys 的 dir 目录读取
+func New(name string, db *gorm.DB, fsys fs.FS, dir string, opts ...Option) *Migrator {
+	m := &Migrator{
+		name:        name,
+		db:          db,
+		fsys:        fsys,
+		dir:         dir,
+		table:       defaultSchemaTable,
+		lockTimeout: time.Minute,
+		log:         &stdLogger{l: log.New(os.Stdout, "", log.LstdFlags)},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// NewForConfig 为 MySqlConfig 中的每个数据库创建迁移器，主库读取 root/main 目录，
+// OtherDns 中的数据库读取 root/{name} 目录，目录不存在的数据库会被跳过
+func NewForConfig(db dbs.IMySqlDB, cfg dbs.MySqlConfig, fsys fs.FS, root string, opts ...Option) ([]*Migrator, error) {
+	names := []string{mainDBName}
+	for name := range cfg.OtherDns {
+		names = append(names, name)
+	}
+	var migrators []*Migrator
+	for _, name := range names {
+		dir := name
+		if root != "" && root != "." {
+			dir = root + "/" + name
+		}
+		if _, err := fs.Stat(fsys, dir); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		gdb := db.MainDB()
+		if name != mainDBName {
+			gdb = db.OtherDB(name)
+		}
+		migrators = append(migrators, New(name, gdb, fsys, dir, opts...))
+	}
+	return migrators, nil
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	Dirty     bool
+	Modified  bool // 已执行的迁移文件被修改过
+	AppliedAt *time.Time
+}
+
+type appliedRecord struct {
+	Version   int64     `gorm:"column:version"`
+	Name      string    `gorm:"column:name"`
+	Checksum  string    `gorm:"column:checksum"`
+	Dirty     bool      `gorm:"column:dirty"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+// conn 迁移的读写都走主库，从库上的版本表可能落后于刚完成的迁移
+func (m *Migrator) conn(ctx context.Context) *gorm.DB {
+	return m.db.WithContext(dbs.WithPrimary(ctx))
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	if m.dryRun {
+		return nil
+	}
+	return m.conn(ctx).Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ("+
+		"`version` bigint NOT NULL,"+
+		"`name` varchar(255) NOT NULL,"+
+		"`checksum` char(64) NOT NULL,"+
+		"`dirty` tinyint(1) NOT NULL DEFAULT 0,"+
+		"`applied_at` datetime NOT NULL,"+
+		"PRIMARY KEY (`version`)"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4", m.table)).Error
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]*appliedRecord, error) {
+	var records []*appliedRecord
+	if m.dryRun && !m.conn(ctx).Migrator().HasTable(m.table) {
+		return map[int64]*appliedRecord{}, nil
+	}
+	if err := m.conn(ctx).Table(m.table).Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[int64]*appliedRecord, len(records))
+	for _, r := range records {
+		res[r.Version] = r
+	}
+	return res, nil
+}
+
+// withLock 使用 GET_LOCK 保证多个副本同时启动时只有一个在执行迁移
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	lockName := "migrate:" + m.table
+	timeout := int64(math.Ceil(m.lockTimeout.Seconds()))
+	var res sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, timeout).Scan(&res); err != nil {
+		return err
+	}
+	if !res.Valid || res.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+	}()
+	if err = m.ensureTable(ctx); err != nil {
+		return err
+	}
+	return fn()
+}
+
+func (m *Migrator) load() ([]*Migration, error) {
+	return loadMigrations(m.fsys, m.dir)
+}
+
+// Status 所有迁移的状态，包括已执行但文件已不存在的版本
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	if err = m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res []MigrationStatus
+	for _, mg := range migrations {
+		st := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if r, ok := applied[mg.Version]; ok {
+			at := r.AppliedAt
+			st.Applied = true
+			st.Dirty = r.Dirty
+			st.Modified = r.Checksum != mg.Checksum
+			st.AppliedAt = &at
+			delete(applied, mg.Version)
+		}
+		res = append(res, st)
+	}
+	for _, r := range applied {
+		at := r.AppliedAt
+		res = append(res, MigrationStatus{Version: r.Version, Name: r.Name, Applied: true, Dirty: r.Dirty, AppliedAt: &at})
+	}
+	return res, nil
+}
+
+// check 有脏数据或已执行的迁移被修改时拒绝继续
+func (m *Migrator) check(migrations []*Migration, applied map[int64]*appliedRecord) error {
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, mg := range migrations {
+		byVersion[mg.Version] = mg
+	}
+	for _, r := range applied {
+		if r.Dirty {
+			return fmt.Errorf("%w: version %d", ErrDirty, r.Version)
+		}
+		if mg, ok := byVersion[r.Version]; ok && mg.Checksum != r.Checksum {
+			return fmt.Errorf("%w: version %d %s", ErrChecksumMismatch, r.Version, mg.Name)
+		}
+	}
+	return nil
+}
+
+// Up 按版本顺序执行未执行的迁移，n <= 0 时执行全部
+func (m *Migrator) Up(ctx context.Context, n int) error {
+	migrations, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		if err = m.check(migrations, applied); err != nil {
+			return err
+		}
+		count := 0
+		for _, mg := range migrations {
+			if n > 0 && count >= n {
+				break
+			}
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err = m.apply(ctx, mg, mg.Up, true); err != nil {
+				return err
+			}
+			count++
+		}
+		if count == 0 {
+			m.log.Infof("[%s] no change", m.name)
+		}
+		return nil
+	})
+}
+
+// Down 按版本倒序回滚最近执行的 n 个迁移
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("migrate: down requires a positive count")
+	}
+	migrations, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		if err = m.check(migrations, applied); err != nil {
+			return err
+		}
+		count := 0
+		for i := len(migrations) - 1; i >= 0 && count < n; i-- {
+			mg := migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(mg.Down) == "" {
+				return fmt.Errorf("%w: version %d %s", ErrNoDownMigration, mg.Version, mg.Name)
+			}
+			if err = m.apply(ctx, mg, mg.Down, false); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+}
+
+// Force 不执行任何 SQL，把数据库标记为 version 版本：小于等于 version 的迁移记为已执行，
+// 大于 version 的记录被删除，同时清除脏标记并刷新校验和。用于手动修复失败的迁移
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	migrations, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.withLock(ctx, func() error {
+		if m.dryRun {
+			m.log.Infof("[%s] force version %d (dry run)", m.name, version)
+			return nil
+		}
+		return m.conn(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(fmt.Sprintf("DELETE FROM `%s` WHERE version > ?", m.table), version).Error; err != nil {
+				return err
+			}
+			for _, mg := range migrations {
+				if mg.Version > version {
+					break
+				}
+				err := tx.Exec(fmt.Sprintf("INSERT INTO `%s` (version, name, checksum, dirty, applied_at) VALUES (?, ?, ?, 0, NOW()) "+
+					"ON DUPLICATE KEY UPDATE name = VALUES(name), checksum = VALUES(checksum), dirty = 0", m.table),
+					mg.Version, mg.Name, mg.Checksum).Error
+				if err != nil {
+					return err
+				}
+			}
+			m.log.Infof("[%s] forced version %d", m.name, version)
+			return nil
+		})
+	})
+}
+
+// apply 执行一个迁移。mysql 的 DDL 不支持事务，执行前先写入脏标记，全部成功后再清除
+func (m *Migrator) apply(ctx context.Context, mg *Migration, content string, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	stmts := splitStatements(content)
+	m.log.Infof("[%s] %s %d_%s", m.name, direction, mg.Version, mg.Name)
+	if m.dryRun {
+		for _, stmt := range stmts {
+			m.log.Infof("[%s] %s;", m.name, stmt)
+		}
+		return nil
+	}
+	db := m.conn(ctx)
+	err := db.Exec(fmt.Sprintf("INSERT INTO `%s` (version, name, checksum, dirty, applied_at) VALUES (?, ?, ?, 1, NOW()) "+
+		"ON DUPLICATE KEY UPDATE dirty = 1", m.table), mg.Version, mg.Name, mg.Checksum).Error
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if err = db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("migrate: %s %d_%s: %w", direction, mg.Version, mg.Name, err)
+		}
+	}
+	if up {
+		return db.Exec(fmt.Sprintf("UPDATE `%s` SET dirty = 0, applied_at = NOW() WHERE version = ?", m.table), mg.Version).Error
+	}
+	return db.Exec(fmt.Sprintf("DELETE FROM `%s` WHERE version = ?", m.table), mg.Version).Error
+}
+
+// Run 以命令行的形式执行：status | up [N] | down N | force VERSION
+func (m *Migrator) Run(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate: missing command")
+	}
+	argInt := func(required bool) (int64, error) {
+		if len(args) < 2 {
+			if required {
+				return 0, fmt.Errorf("migrate: %s requires an argument", args[0])
+			}
+			return 0, nil
+		}
+		return strconv.ParseInt(args[1], 10, 64)
+	}
+	switch args[0] {
+	case "status":
+		list, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range list {
+			state := "pending"
+			switch {
+			case st.Dirty:
+				state = "dirty"
+			case st.Modified:
+				state = "modified"
+			case st.Applied:
+				state = "applied"
+			}
+			m.log.Infof("[%s] %d_%s %s", m.name, st.Version, st.Name, state)
+		}
+		return nil
+	case "up":
+		n, err := argInt(false)
+		if err != nil {
+			return err
+		}
+		return m.Up(ctx, int(n))
+	case "down":
+		n, err := argInt(true)
+		if err != nil {
+			return err
+		}
+		return m.Down(ctx, int(n))
+	case "force":
+		v, err := argInt(true)
+		if err != nil {
+			return err
+		}
+		return m.Force(ctx, v)
+	default:
+		return fmt.Errorf("migrate: unknown command %q", args[0])
+	}
+}
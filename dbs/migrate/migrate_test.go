@@ -0,0 +1,286 @@
+package migrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs"
+	"github.com/ville-vv/gutils/dbs/sqltest"
+)
+
+var testMigrations = fstest.MapFS{
+	"sql/0001_init.up.sql":       {Data: []byte("CREATE TABLE user (id int);\nCREATE TABLE book (id int);")},
+	"sql/0001_init.down.sql":     {Data: []byte("DROP TABLE book;\nDROP TABLE user;")},
+	"sql/0002_add_name.up.sql":   {Data: []byte("ALTER TABLE user ADD name varchar(32);")},
+	"sql/0002_add_name.down.sql": {Data: []byte("ALTER TABLE user DROP name;")},
+}
+
+// failMigrations 第 3 个版本执行到一半失败，并且没有 down 文件
+var failMigrations = fstest.MapFS{
+	"sql/0001_init.up.sql":     testMigrations["sql/0001_init.up.sql"],
+	"sql/0002_add_name.up.sql": testMigrations["sql/0002_add_name.up.sql"],
+	"sql/0003_fail.up.sql":     {Data: []byte("ALTER TABLE user ADD age int;\nFAIL;")},
+}
+
+type schemaRow struct {
+	name     string
+	checksum string
+	dirty    bool
+}
+
+// fakeSchema 在内存中模拟 schema_migrations 表，其他语句只记录，FAIL 语句返回错误
+type fakeSchema struct {
+	mu      sync.Mutex
+	rows    map[int64]*schemaRow
+	exists  bool
+	locked  bool
+	lockArg int64
+}
+
+func (f *fakeSchema) handle(query string, args []driver.NamedValue) sqltest.Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arg := func(i int) interface{} { return args[i].Value }
+	switch {
+	case strings.HasPrefix(query, "SELECT GET_LOCK"):
+		f.lockArg = arg(1).(int64)
+		res := int64(1)
+		if f.locked {
+			res = 0
+		}
+		return sqltest.Result{Columns: []string{"res"}, Rows: [][]driver.Value{{res}}}
+	case strings.HasPrefix(query, "SELECT DATABASE()"):
+		return sqltest.Result{Columns: []string{"DATABASE()"}, Rows: [][]driver.Value{{"test"}}}
+	case strings.HasPrefix(query, "SELECT count(*) FROM information_schema.tables"):
+		n := int64(0)
+		if f.exists {
+			n = 1
+		}
+		return sqltest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{n}}}
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS `schema_migrations`"):
+		f.exists = true
+	case strings.HasPrefix(query, "SELECT * FROM `schema_migrations`"):
+		versions := make([]int64, 0, len(f.rows))
+		for v := range f.rows {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+		res := sqltest.Result{Columns: []string{"version", "name", "checksum", "dirty", "applied_at"}}
+		for _, v := range versions {
+			r := f.rows[v]
+			res.Rows = append(res.Rows, []driver.Value{v, r.name, r.checksum, r.dirty, time.Now()})
+		}
+		return res
+	case strings.HasPrefix(query, "INSERT INTO `schema_migrations`"):
+		v := arg(0).(int64)
+		r, ok := f.rows[v]
+		if !ok {
+			r = &schemaRow{name: arg(1).(string), checksum: arg(2).(string)}
+			f.rows[v] = r
+		}
+		if strings.Contains(query, "VALUES (?, ?, ?, 1,") {
+			r.dirty = true
+		} else {
+			r.name, r.checksum, r.dirty = arg(1).(string), arg(2).(string), false
+		}
+	case strings.HasPrefix(query, "UPDATE `schema_migrations` SET dirty = 0"):
+		f.rows[arg(0).(int64)].dirty = false
+	case strings.HasPrefix(query, "DELETE FROM `schema_migrations` WHERE version = ?"):
+		delete(f.rows, arg(0).(int64))
+	case strings.HasPrefix(query, "DELETE FROM `schema_migrations` WHERE version > ?"):
+		for v := range f.rows {
+			if v > arg(0).(int64) {
+				delete(f.rows, v)
+			}
+		}
+	case query == "FAIL":
+		return sqltest.Result{Err: errors.New("syntax error")}
+	}
+	return sqltest.Result{}
+}
+
+func (f *fakeSchema) versions() map[int64]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make(map[int64]bool, len(f.rows))
+	for v, r := range f.rows {
+		res[v] = r.dirty
+	}
+	return res
+}
+
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func newTestMigrator(t *testing.T, fsys fstest.MapFS, opts ...Option) (*Migrator, *fakeSchema, *sqltest.DB) {
+	schema := &fakeSchema{rows: map[int64]*schemaRow{}}
+	db := sqltest.Open(t, schema.handle)
+	opts = append([]Option{WithLogger(&recordLogger{})}, opts...)
+	return New("main", db.Gorm(t), fsys, "sql", opts...), schema, db
+}
+
+// ddl 过滤出迁移文件中的语句
+func ddl(queries []string) []string {
+	var res []string
+	for _, q := range queries {
+		if strings.Contains(q, " user") || strings.Contains(q, " book") || q == "FAIL" {
+			res = append(res, q)
+		}
+	}
+	return res
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	m, schema, db := newTestMigrator(t, testMigrations)
+	ctx := context.Background()
+
+	require.NoError(t, m.Up(ctx, 0))
+	assert.Equal(t, map[int64]bool{1: false, 2: false}, schema.versions())
+	assert.Equal(t, []string{
+		"CREATE TABLE user (id int)",
+		"CREATE TABLE book (id int)",
+		"ALTER TABLE user ADD name varchar(32)",
+	}, ddl(db.Queries()))
+	queries := db.Queries()
+	assert.Equal(t, "SELECT RELEASE_LOCK(?)", queries[len(queries)-1])
+
+	// 已执行的版本不会重复执行
+	db.Reset()
+	require.NoError(t, m.Up(ctx, 0))
+	assert.Empty(t, ddl(db.Queries()))
+
+	db.Reset()
+	require.NoError(t, m.Run(ctx, "down", "1"))
+	assert.Equal(t, []string{"ALTER TABLE user DROP name"}, ddl(db.Queries()))
+	assert.Equal(t, map[int64]bool{1: false}, schema.versions())
+
+	list, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.True(t, list[0].Applied)
+	assert.False(t, list[1].Applied)
+
+	db.Reset()
+	require.NoError(t, m.Run(ctx, "up", "1"))
+	assert.Equal(t, []string{"ALTER TABLE user ADD name varchar(32)"}, ddl(db.Queries()))
+	assert.Error(t, m.Down(ctx, 0))
+
+	db.Reset()
+	require.NoError(t, m.Down(ctx, 5))
+	assert.Equal(t, []string{
+		"ALTER TABLE user DROP name",
+		"DROP TABLE book",
+		"DROP TABLE user",
+	}, ddl(db.Queries()))
+	assert.Empty(t, schema.versions())
+}
+
+func TestMigrator_DirtyAndForce(t *testing.T) {
+	m, schema, db := newTestMigrator(t, failMigrations)
+	ctx := context.Background()
+
+	// 迁移中途失败时保留脏标记，之后拒绝继续执行
+	err := m.Up(ctx, 0)
+	assert.ErrorContains(t, err, "syntax error")
+	assert.Equal(t, map[int64]bool{1: false, 2: false, 3: true}, schema.versions())
+	assert.ErrorIs(t, m.Up(ctx, 0), ErrDirty)
+	assert.ErrorIs(t, m.Down(ctx, 1), ErrDirty)
+
+	list, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.True(t, list[2].Dirty)
+
+	// 手动修复后标记为已执行
+	require.NoError(t, m.Run(ctx, "force", "3"))
+	assert.Equal(t, map[int64]bool{1: false, 2: false, 3: false}, schema.versions())
+	db.Reset()
+	require.NoError(t, m.Up(ctx, 0))
+	assert.Empty(t, ddl(db.Queries()))
+
+	require.NoError(t, m.Force(ctx, 1))
+	assert.Equal(t, map[int64]bool{1: false}, schema.versions())
+}
+
+func TestMigrator_ChecksumMismatch(t *testing.T) {
+	m, schema, db := newTestMigrator(t, failMigrations)
+	ctx := context.Background()
+	schema.rows[1] = &schemaRow{name: "init", checksum: "edited"}
+
+	assert.ErrorIs(t, m.Up(ctx, 0), ErrChecksumMismatch)
+	assert.Empty(t, ddl(db.Queries()))
+
+	list, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.True(t, list[0].Modified)
+
+	// 没有 down 文件的版本无法回滚
+	schema.rows[1].checksum = checksum(string(testMigrations["sql/0001_init.up.sql"].Data))
+	schema.rows[3] = &schemaRow{name: "fail", checksum: checksum(string(failMigrations["sql/0003_fail.up.sql"].Data))}
+	assert.ErrorIs(t, m.Down(ctx, 1), ErrNoDownMigration)
+}
+
+func TestMigrator_DryRun(t *testing.T) {
+	log := &recordLogger{}
+	m, schema, db := newTestMigrator(t, failMigrations, WithDryRun(), WithLogger(log))
+	ctx := context.Background()
+
+	require.NoError(t, m.Up(ctx, 0))
+	assert.Empty(t, ddl(db.Queries()))
+	assert.Empty(t, schema.versions())
+	assert.False(t, schema.exists)
+	assert.Contains(t, log.lines, "[main] CREATE TABLE user (id int);")
+	assert.Contains(t, log.lines, "[main] FAIL;")
+
+	require.NoError(t, m.Force(ctx, 1))
+	assert.Empty(t, schema.versions())
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	m, schema, _ := newTestMigrator(t, testMigrations, WithLockTimeout(300*time.Millisecond))
+	ctx := context.Background()
+
+	// 不足 1 秒的等待时间向上取整，不能变成 GET_LOCK(name, 0)
+	require.NoError(t, m.Up(ctx, 1))
+	assert.Equal(t, int64(1), schema.lockArg)
+
+	schema.locked = true
+	assert.ErrorIs(t, m.Up(ctx, 0), ErrLockTimeout)
+}
+
+func TestMigrator_Primary(t *testing.T) {
+	schema := &fakeSchema{rows: map[int64]*schemaRow{}}
+	primary := sqltest.Open(t, schema.handle)
+	// 从库上的版本表落后，读到的话会重复执行已经执行过的迁移
+	replica := sqltest.Open(t, func(query string, args []driver.NamedValue) sqltest.Result {
+		return sqltest.Result{Err: errors.New("read from replica")}
+	})
+	gdb := primary.Gorm(t)
+	plugin := dbs.NewReplicaPlugin("main", dbs.ReplicaConfig{}, replica.DB)
+	t.Cleanup(plugin.Close)
+	require.NoError(t, gdb.Use(plugin))
+
+	ctx := dbs.WithReplica(context.Background())
+	m := New("main", gdb, testMigrations, "sql", WithLogger(&recordLogger{}))
+	require.NoError(t, m.Up(ctx, 0))
+	_, err := m.Status(ctx)
+	require.NoError(t, err)
+
+	dry := New("main", gdb, testMigrations, "sql", WithDryRun(), WithLogger(&recordLogger{}))
+	require.NoError(t, dry.Up(ctx, 0))
+	assert.Empty(t, replica.Queries())
+}
@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 文件名格式：{version}_{name}.up.sql / {version}_{name}.down.sql
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration 一个版本的迁移
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // up 文件内容的 sha256
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadMigrations 读取 dir 目录下的迁移文件并按版本排序，目录可以来自 os.DirFS 或 embed.FS
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := fileNameRegexp.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version in %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mg
+		} else if mg.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d has different names %q and %q", version, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(content)
+			mg.Checksum = checksum(mg.Up)
+		} else {
+			mg.Down = string(content)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Checksum == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up file", mg.Version)
+		}
+		migrations = append(migrations, mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitStatements 按分号拆分多条语句，忽略引号和注释中的分号。
+// 不支持 DELIMITER，存储过程需要单独放在一个文件中并且不包含其他语句
+func splitStatements(content string) []string {
+	var (
+		stmts []string
+		buf   strings.Builder
+		quote byte
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(buf.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		buf.Reset()
+	}
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if quote != 0 {
+			buf.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(content) {
+				i++
+				buf.WriteByte(content[i])
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			buf.WriteByte(c)
+		case c == '#' || (c == '-' && strings.HasPrefix(content[i:], "-- ")):
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			buf.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += end + 3
+			}
+			buf.WriteByte(' ')
+		case c == ';':
+			flush()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return stmts
+}
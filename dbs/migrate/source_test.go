@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/main/0002_add_index.up.sql":     {Data: []byte("CREATE INDEX idx_name ON user (name);")},
+		"sql/main/0001_init.up.sql":          {Data: []byte("CREATE TABLE user (id int);")},
+		"sql/main/0001_init.down.sql":        {Data: []byte("DROP TABLE user;")},
+		"sql/main/README.md":                 {Data: []byte("ignored")},
+		"sql/main/0003_only_down.down.sql":   {Data: []byte("SELECT 1;")},
+		"sql/other/0001_init.up.sql":         {Data: []byte("SELECT 1;")},
+		"sql/broken/0001_a.up.sql":           {Data: []byte("SELECT 1;")},
+		"sql/broken/0001_b.up.sql":           {Data: []byte("SELECT 1;")},
+		"sql/valid/0010_ten.up.sql":          {Data: []byte("SELECT 10;")},
+		"sql/valid/0009_nine.up.sql":         {Data: []byte("SELECT 9;")},
+		"sql/valid/0009_nine.down.sql":       {Data: []byte("SELECT -9;")},
+		"sql/valid/nested/0001_skip.up.sql":  {Data: []byte("SELECT 1;")},
+		"sql/valid/0011_eleven.down.sql.bak": {Data: []byte("SELECT 11;")},
+	}
+
+	_, err := loadMigrations(fsys, "sql/main")
+	assert.Error(t, err)
+	_, err = loadMigrations(fsys, "sql/broken")
+	assert.Error(t, err)
+
+	list, err := loadMigrations(fsys, "sql/valid")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, int64(9), list[0].Version)
+		assert.Equal(t, "nine", list[0].Name)
+		assert.Equal(t, "SELECT -9;", list[0].Down)
+		assert.Equal(t, int64(10), list[1].Version)
+		assert.Equal(t, checksum("SELECT 10;"), list[1].Checksum)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	content := `
+-- create table; with comment
+CREATE TABLE t (
+	id int, # trailing; comment
+	name varchar(32) DEFAULT 'a;b'
+);
+/* block; comment */
+INSERT INTO t (name) VALUES ("x\";y");
+UPDATE ` + "`t;`" + ` SET name = 'it''s'
+`
+	stmts := splitStatements(content)
+	if assert.Len(t, stmts, 3) {
+		assert.Contains(t, stmts[0], "DEFAULT 'a;b'")
+		assert.Equal(t, `INSERT INTO t (name) VALUES ("x\";y")`, stmts[1])
+		assert.Equal(t, "UPDATE `t;` SET name = 'it''s'", stmts[2])
+	}
+}
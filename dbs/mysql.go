@@ -2,6 +2,7 @@ package dbs
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"gorm.io/driver/mysql"
@@ -9,12 +10,14 @@ import (
 	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
 	mysqlEnvDev = "dev"
+	mainDBName  = "main"
 )
 
 var (
@@ -35,6 +38,16 @@ type IMySqlDB interface {
 	ClearAllData(db *gorm.DB, tables ...string)
 }
 
+// IMySqlDBHealth 连接池的健康检查、统计和关闭，MysqlDB 实现了该接口，
+// 拿到的是 IMySqlDB 时通过类型断言使用：if h, ok := db.(IMySqlDBHealth); ok { ... }
+type IMySqlDBHealth interface {
+	HealthCheck(ctx context.Context) error
+	Stats() map[string]sql.DBStats
+	Close() error
+}
+
+var _ IMySqlDBHealth = (*MysqlDB)(nil)
+
 type MySqlConfig struct {
 	Env         string            `json:"env,optional"`
 	OtherDns    map[string]string `json:"otherDns,optional" yaml:"otherDns"`
@@ -43,6 +56,12 @@ type MySqlConfig struct {
 	MaxOpenConn int               `json:"maxOpenConn,optional" yaml:"maxOpenConn"`
 	LogLevel    string            `json:"logLevel,optional" yaml:"logLevel"`
 	SlowLogTm   int               `json:"slowLogTm,optional" yaml:"slowLogTm"`
+	ConnRetry   int               `json:"connRetry,optional" yaml:"connRetry"` // 启动时连接失败的重试次数
+	UseZLog     bool              `json:"useZLog,optional" yaml:"useZLog"`     // 通过 zlog 输出 sql 日志，带上 ctx 中的 trace/span
+	LogRedact   bool              `json:"logRedact,optional" yaml:"logRedact"` // sql 日志中不输出参数值
+	// Replicas 主库的只读从库，OtherReplicas 按 OtherDns 的名称配置对应的从库，只有 WithReplica 标记的查询走从库
+	Replicas      ReplicaConfig            `json:"replicas,optional" yaml:"replicas"`
+	OtherReplicas map[string]ReplicaConfig `json:"otherReplicas,optional" yaml:"otherReplicas"`
 }
 
 func (sel *MySqlConfig) GetEnv() string {
@@ -60,6 +79,10 @@ func (sel *MySqlConfig) GetMaxOpenConn() int {
 	return getIntWithDefault(sel.MaxOpenConn, 20)
 }
 
+func (sel *MySqlConfig) GetConnRetry() int {
+	return getIntWithDefault(sel.ConnRetry, 3)
+}
+
 func (sel *MySqlConfig) GetSlowLogTm() time.Duration {
 	if sel.SlowLogTm == 0 {
 		sel.SlowLogTm = 2
@@ -101,41 +124,165 @@ func logLevel(level string) logger.LogLevel {
 }
 
 type MysqlDB struct {
-	env      string
-	mainDb   *gorm.DB
-	otherDbs map[string]*gorm.DB
+	env       string
+	mainDb    *gorm.DB
+	otherDbs  map[string]*gorm.DB
+	resolvers map[string]*ReplicaPlugin
+	closeOnce sync.Once
 }
 
 func InitMysqlDB(cfg MySqlConfig, l ...MySqlLogger) IMySqlDB {
 	_mysqlDbOnce.Do(func() {
-		var newLogger logger.Interface
-		if len(l) > 0 {
-			newLogger = newDefaultLogger(&cfg, l[0])
-		} else {
-			newLogger = logger.New(
-				log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
-				logger.Config{
-					SlowThreshold:             cfg.GetSlowLogTm(), // Slow SQL threshold
-					LogLevel:                  cfg.GetLogLevel(),  // Log level
-					IgnoreRecordNotFoundError: true,               // Ignore ErrRecordNotFound error for logger
-					ParameterizedQueries:      false,              // Don't include params in the SQL log
-					Colorful:                  false,              // Disable color
-				},
-			)
+		db, err := NewMysqlDB(context.Background(), cfg, l...)
+		if err != nil {
+			panic(err)
+		}
+		_mysqlDB = db.(*MysqlDB)
+		SetDefaultMysqlDB(_mysqlDB)
+	})
+
+	return _mysqlDB
+}
+
+// NewMysqlDB 创建 mysql 连接，可以创建多个实例。连接失败时按指数退避重试，
+// 重试次数用完或 ctx 结束时返回错误，已经打开的连接会被关闭
+func NewMysqlDB(ctx context.Context, cfg MySqlConfig, l ...MySqlLogger) (IMySqlDB, error) {
+	var newLogger logger.Interface
+	switch {
+	case cfg.UseZLog:
+		newLogger = NewZLogGormLogger(&cfg)
+	case len(l) > 0:
+		newLogger = newDefaultLogger(&cfg, l[0])
+	default:
+		newLogger = logger.New(
+			log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
+			logger.Config{
+				SlowThreshold:             cfg.GetSlowLogTm(), // Slow SQL threshold
+				LogLevel:                  cfg.GetLogLevel(),  // Log level
+				IgnoreRecordNotFoundError: true,               // Ignore ErrRecordNotFound error for logger
+				ParameterizedQueries:      cfg.LogRedact,      // Don't include params in the SQL log
+				Colorful:                  false,              // Disable color
+			},
+		)
+	}
+	db := &MysqlDB{
+		env:       cfg.Env,
+		otherDbs:  make(map[string]*gorm.DB),
+		resolvers: make(map[string]*ReplicaPlugin),
+	}
+	var err error
+	if db.mainDb, err = openGormDBWithRetry(ctx, &cfg, cfg.MainDns, newLogger); err != nil {
+		return nil, fmt.Errorf("open %s: %w", mainDBName, err)
+	}
+	if err = db.useReplicas(mainDBName, db.mainDb, cfg.Replicas, &cfg); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	for k, v := range cfg.OtherDns {
+		other, err := openGormDBWithRetry(ctx, &cfg, v, newLogger)
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("open %s: %w", k, err)
+		}
+		db.otherDbs[k] = other
+		if err = db.useReplicas(k, other, cfg.OtherReplicas[k], &cfg); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// useReplicas 配置了从库时注册读写分离插件
+func (sel *MysqlDB) useReplicas(name string, db *gorm.DB, rc ReplicaConfig, cfg *MySqlConfig) error {
+	if len(rc.Dns) == 0 {
+		return nil
+	}
+	resolver, err := openReplicaPlugin(name, rc, cfg.GetMaxIdleConn(), cfg.GetMaxOpenConn())
+	if err != nil {
+		return fmt.Errorf("open %s replicas: %w", name, err)
+	}
+	if err = db.Use(resolver); err != nil {
+		resolver.Close()
+		return err
+	}
+	sel.resolvers[name] = resolver
+	return nil
+}
+
+// ReplicaStats 从库的健康状态、复制延迟和连接池状态，name 为空时返回主库的从库
+func (sel *MysqlDB) ReplicaStats(name string) []ReplicaStat {
+	if name == "" {
+		name = mainDBName
+	}
+	if r, ok := sel.resolvers[name]; ok {
+		return r.Stats()
+	}
+	return nil
+}
+
+// pools 所有连接池，key 为库名，从库为 库名/replica-序号
+func (sel *MysqlDB) pools() map[string]*sql.DB {
+	pools := make(map[string]*sql.DB)
+	add := func(name string, db *gorm.DB) {
+		if db == nil {
+			return
 		}
-		_mysqlDB = new(MysqlDB)
-		_mysqlDB.env = cfg.Env
-		_mysqlDB.otherDbs = make(map[string]*gorm.DB)
-		_mysqlDB.mainDb = openGormDB(cfg.MainDns, cfg.GetMaxIdleConn(), cfg.GetMaxOpenConn(), newLogger)
-		if len(cfg.OtherDns) > 0 {
-			for k, v := range cfg.OtherDns {
-				_mysqlDB.otherDbs[k] = openGormDB(v, cfg.MaxIdleConn, cfg.MaxOpenConn, newLogger)
+		if sqlDb, err := db.DB(); err == nil {
+			pools[name] = sqlDb
+		}
+		if r, ok := sel.resolvers[name]; ok {
+			for i, rp := range r.replicas {
+				pools[fmt.Sprintf("%s/replica-%d", name, i)] = rp.db
 			}
 		}
+	}
+	add(mainDBName, sel.mainDb)
+	for k, v := range sel.otherDbs {
+		add(k, v)
+	}
+	return pools
+}
 
-	})
+// HealthCheck ping 所有连接池（包括从库），返回所有失败的连接池错误
+func (sel *MysqlDB) HealthCheck(ctx context.Context) error {
+	var errs []error
+	for name, db := range sel.pools() {
+		if err := db.PingContext(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	return _mysqlDB
+// Stats 每个连接池的 sql.DBStats
+func (sel *MysqlDB) Stats() map[string]sql.DBStats {
+	pools := sel.pools()
+	stats := make(map[string]sql.DBStats, len(pools))
+	for name, db := range pools {
+		stats[name] = db.Stats()
+	}
+	return stats
+}
+
+// Close 停止从库健康检查并关闭所有连接池
+func (sel *MysqlDB) Close() error {
+	var errs []error
+	sel.closeOnce.Do(func() {
+		pools := sel.pools()
+		for _, r := range sel.resolvers {
+			r.Close()
+		}
+		for name, db := range pools {
+			if strings.Contains(name, "/replica-") {
+				continue
+			}
+			if err := db.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
 }
 
 func (sel *MysqlDB) MainDB() *gorm.DB {
@@ -149,6 +296,9 @@ func (sel *MysqlDB) OtherDB(name string) *gorm.DB {
 	return sel.otherDbs[name]
 }
 
+// ClearAllData 在 dev 环境下清空表数据
+//
+// Deprecated: 测试数据请使用 dbs/fixtures，它只清空测试数据涉及的表并且会校验数据库名
 func (sel *MysqlDB) ClearAllData(db *gorm.DB, tables ...string) {
 	if sel.env == mysqlEnvDev {
 		if len(tables) <= 0 {
@@ -160,7 +310,29 @@ func (sel *MysqlDB) ClearAllData(db *gorm.DB, tables ...string) {
 	}
 }
 
-func openGormDB(dns string, maxIdleConns int, maxOpenConns int, l logger.Interface) *gorm.DB {
+// openGormDBWithRetry 打开连接，失败时按 500ms 起步、最长 10s 的指数退避重试
+func openGormDBWithRetry(ctx context.Context, cfg *MySqlConfig, dns string, l logger.Interface) (*gorm.DB, error) {
+	backoff := time.Millisecond * 500
+	for i := 0; ; i++ {
+		db, err := openGormDB(ctx, dns, cfg.GetMaxIdleConn(), cfg.GetMaxOpenConn(), l)
+		if err == nil {
+			return db, nil
+		}
+		if i >= cfg.GetConnRetry() {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Second*10 {
+			backoff = time.Second * 10
+		}
+	}
+}
+
+func openGormDB(ctx context.Context, dns string, maxIdleConns int, maxOpenConns int, l logger.Interface) (*gorm.DB, error) {
 	db, err := gorm.Open(mysql.New(mysql.Config{
 		DSN:                           dns,
 		DefaultStringSize:             255,
@@ -170,28 +342,31 @@ func openGormDB(dns string, maxIdleConns int, maxOpenConns int, l logger.Interfa
 		DontSupportRenameColumn:       true,
 		DontSupportRenameColumnUnique: true,
 	}), &gorm.Config{
-		Logger: l,
+		Logger:               l,
+		DisableAutomaticPing: true,
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	sqlDb, err := db.DB()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if err = sqlDb.Ping(); err != nil {
-		panic(err)
+	if err = sqlDb.PingContext(ctx); err != nil {
+		_ = sqlDb.Close()
+		return nil, err
 	}
 	sqlDb.SetMaxIdleConns(maxIdleConns)
 	sqlDb.SetMaxOpenConns(maxOpenConns)
 	sqlDb.SetConnMaxLifetime(time.Hour)
-	return db
+	return db, nil
 }
 
 type defaultLogger struct {
 	level         logger.LogLevel
 	log           MySqlLogger
 	SlowThreshold time.Duration
+	redact        bool
 	traceStr      string
 	traceWarnStr  string
 	traceErrStr   string
@@ -201,7 +376,8 @@ func newDefaultLogger(cfg *MySqlConfig, l MySqlLogger) logger.Interface {
 	return &defaultLogger{
 		level:         logLevel(cfg.LogLevel),
 		log:           l,
-		SlowThreshold: time.Second * 2,
+		SlowThreshold: cfg.GetSlowLogTm(),
+		redact:        cfg.LogRedact,
 		traceStr:      "[%.3fms rows:%v] %s",
 		traceWarnStr:  "%s [%.3fms rows:%v] %s",
 		traceErrStr:   "%s [%.3fms rows:%v] %s",
@@ -231,25 +407,28 @@ func (sel *defaultLogger) Error(ctx context.Context, msg string, arg ...interfac
 	}
 }
 
+// ParamsFilter 开启 LogRedact 时去掉参数，日志里只保留占位符
+func (sel *defaultLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if sel.redact {
+		return sql, nil
+	}
+	return sql, params
+}
+
 func (sel *defaultLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
 	elapsed := time.Since(begin)
 	sql, rows := fc()
+	var rowsStr interface{} = rows
+	if rows == -1 {
+		rowsStr = "-"
+	}
 	switch {
 	case err != nil && sel.level >= logger.Error && !errors.Is(err, logger.ErrRecordNotFound):
-		if rows == -1 {
-			sel.log.Errorf(sel.traceErrStr, err.Error(), float64(elapsed.Nanoseconds())/1e6, "-", sql)
-		}
-		sel.log.Errorf(sel.traceErrStr, err.Error(), float64(elapsed.Nanoseconds())/1e6, rows, sql)
+		sel.log.Errorf(sel.traceErrStr, err.Error(), float64(elapsed.Nanoseconds())/1e6, rowsStr, sql)
 	case elapsed > sel.SlowThreshold && sel.SlowThreshold != 0 && sel.level >= logger.Warn:
 		slowLog := fmt.Sprintf("SLOW SQL >= %v", sel.SlowThreshold)
-		if rows == -1 {
-			sel.log.Warnf(sel.traceWarnStr, slowLog, float64(elapsed.Nanoseconds())/1e6, "-", sql)
-		}
-		sel.log.Warnf(sel.traceWarnStr, slowLog, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+		sel.log.Warnf(sel.traceWarnStr, slowLog, float64(elapsed.Nanoseconds())/1e6, rowsStr, sql)
 	case sel.level >= logger.Info:
-		if rows == -1 {
-			sel.log.Infof(sel.traceStr, float64(elapsed.Nanoseconds())/1e6, "-", sql)
-		}
-		sel.log.Infof(sel.traceStr, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+		sel.log.Infof(sel.traceStr, float64(elapsed.Nanoseconds())/1e6, rowsStr, sql)
 	}
 }
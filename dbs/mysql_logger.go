@@ -0,0 +1,89 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ville-vv/gutils/zlog"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// zlogGormLogger 通过 zlog 输出 sql 日志，日志带上 ctx 中的 trace/span，
+// 字段为 sql、rows、elapsed_ms、caller
+type zlogGormLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+	redact        bool
+}
+
+// NewZLogGormLogger 使用配置中的 LogLevel、SlowLogTm、LogRedact 创建 gorm 日志
+func NewZLogGormLogger(cfg *MySqlConfig) logger.Interface {
+	return &zlogGormLogger{
+		level:         cfg.GetLogLevel(),
+		slowThreshold: cfg.GetSlowLogTm(),
+		redact:        cfg.LogRedact,
+	}
+}
+
+func (sel *zlogGormLogger) LogMode(l logger.LogLevel) logger.Interface {
+	nl := *sel
+	nl.level = l
+	return &nl
+}
+
+func (sel *zlogGormLogger) Info(ctx context.Context, msg string, arg ...interface{}) {
+	if logger.Info <= sel.level {
+		zlog.WithContext(ctx).Infof(msg, arg...)
+	}
+}
+
+func (sel *zlogGormLogger) Warn(ctx context.Context, msg string, arg ...interface{}) {
+	if logger.Warn <= sel.level {
+		zlog.WithContext(ctx).Warnf(msg, arg...)
+	}
+}
+
+func (sel *zlogGormLogger) Error(ctx context.Context, msg string, arg ...interface{}) {
+	if logger.Error <= sel.level {
+		zlog.WithContext(ctx).Errorf(msg, arg...)
+	}
+}
+
+// ParamsFilter 开启 LogRedact 时去掉参数，日志里只保留占位符
+func (sel *zlogGormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if sel.redact {
+		return sql, nil
+	}
+	return sql, params
+}
+
+func (sel *zlogGormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if sel.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && sel.level >= logger.Error && !errors.Is(err, logger.ErrRecordNotFound):
+		sql, rows := fc()
+		kvs := append(traceFields(sql, rows, elapsed, utils.FileWithLineNum()), "error", err.Error())
+		zlog.WithContext(ctx).Errorw("sql error", kvs...)
+	case elapsed > sel.slowThreshold && sel.slowThreshold != 0 && sel.level >= logger.Warn:
+		sql, rows := fc()
+		kvs := append(traceFields(sql, rows, elapsed, utils.FileWithLineNum()), "slow_threshold_ms", sel.slowThreshold.Milliseconds())
+		zlog.WithContext(ctx).Warnw("slow sql", kvs...)
+	case sel.level >= logger.Info:
+		sql, rows := fc()
+		zlog.WithContext(ctx).Infow("sql", traceFields(sql, rows, elapsed, utils.FileWithLineNum())...)
+	}
+}
+
+// traceFields rows 为 -1 表示影响行数未知，此时不输出 rows
+func traceFields(sql string, rows int64, elapsed time.Duration, caller string) []interface{} {
+	kvs := []interface{}{"sql", sql}
+	if rows != -1 {
+		kvs = append(kvs, "rows", rows)
+	}
+	return append(kvs, "elapsed_ms", float64(elapsed.Nanoseconds())/1e6, "caller", caller)
+}
@@ -0,0 +1,53 @@
+package dbs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countLogger struct {
+	lines []string
+}
+
+func (c *countLogger) Debugf(format string, args ...interface{}) {
+	c.lines = append(c.lines, fmt.Sprintf(format, args...))
+}
+func (c *countLogger) Infof(format string, args ...interface{}) {
+	c.lines = append(c.lines, fmt.Sprintf(format, args...))
+}
+func (c *countLogger) Warnf(format string, args ...interface{}) {
+	c.lines = append(c.lines, fmt.Sprintf(format, args...))
+}
+func (c *countLogger) Errorf(format string, args ...interface{}) {
+	c.lines = append(c.lines, fmt.Sprintf(format, args...))
+}
+
+func TestDefaultLogger_Trace(t *testing.T) {
+	l := &countLogger{}
+	lg := newDefaultLogger(&MySqlConfig{LogLevel: "info", SlowLogTm: 5, LogRedact: true}, l)
+	lg.Trace(context.Background(), time.Now(), func() (string, int64) { return "select 1", -1 }, nil)
+	if assert.Len(t, l.lines, 1) {
+		assert.Contains(t, l.lines[0], "rows:-]")
+	}
+	assert.Equal(t, 5*time.Second, lg.(*defaultLogger).SlowThreshold)
+
+	sql, vars := lg.(*defaultLogger).ParamsFilter(context.Background(), "select ?", 1)
+	assert.Equal(t, "select ?", sql)
+	assert.Nil(t, vars)
+}
+
+func TestTraceFields(t *testing.T) {
+	kvs := traceFields("select 1", -1, time.Millisecond, "a.go:1")
+	assert.Equal(t, []interface{}{"sql", "select 1", "elapsed_ms", 1.0, "caller", "a.go:1"}, kvs)
+	kvs = traceFields("select 1", 2, time.Millisecond, "a.go:1")
+	assert.Equal(t, int64(2), kvs[3])
+
+	lg := NewZLogGormLogger(&MySqlConfig{LogLevel: "info"}).(*zlogGormLogger)
+	_, vars := lg.ParamsFilter(context.Background(), "select ?", 1)
+	assert.Equal(t, []interface{}{1}, vars)
+	assert.Equal(t, 2*time.Second, lg.slowThreshold)
+}
@@ -0,0 +1,322 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReplicaPolicyRoundRobin = "round_robin"
+	ReplicaPolicyLeastConn  = "least_conn"
+)
+
+type (
+	forcePrimaryKey struct{}
+	replicaReadKey  struct{}
+)
+
+// WithReplica 标记 ctx 中的查询可以走从库。默认所有查询都走主库，
+// 只有能够容忍复制延迟的读取才需要加上这个标记
+//
+//	db.WithContext(dbs.WithReplica(ctx)).Find(&users)
+func WithReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadKey{}, true)
+}
+
+// WithPrimary 标记 ctx 中的查询强制走主库，优先于 WithReplica，
+// 用于在允许读从库的 ctx 中写后立即读
+//
+//	db.WithContext(dbs.WithPrimary(ctx)).First(&user)
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+func isForcePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return v
+}
+
+func isReplicaRead(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(replicaReadKey{}).(bool)
+	return v && !isForcePrimary(ctx)
+}
+
+// ReplicaConfig 只读从库配置
+type ReplicaConfig struct {
+	Dns           []string `json:"dns,optional" yaml:"dns"`
+	Policy        string   `json:"policy,optional" yaml:"policy"`               // round_robin 或 least_conn，默认 round_robin
+	MaxLag        int      `json:"maxLag,optional" yaml:"maxLag"`               // 最大复制延迟（秒），超过后摘除，0 表示不检查
+	CheckInterval int      `json:"checkInterval,optional" yaml:"checkInterval"` // 健康检查间隔（秒）
+}
+
+func (sel *ReplicaConfig) GetPolicy() string {
+	if sel.Policy == "" {
+		sel.Policy = ReplicaPolicyRoundRobin
+	}
+	return sel.Policy
+}
+
+func (sel *ReplicaConfig) GetCheckInterval() time.Duration {
+	return time.Duration(getIntWithDefault(sel.CheckInterval, 5)) * time.Second
+}
+
+type replica struct {
+	dns     string
+	db      *sql.DB
+	healthy atomic.Bool
+	lag     atomic.Int64 // 秒，-1 表示未知
+}
+
+// ReplicaStat 从库状态
+type ReplicaStat struct {
+	Healthy bool
+	Lag     int64
+	Stats   sql.DBStats
+}
+
+// ReplicaPlugin 读写分离的 gorm 插件，只把 WithReplica 标记的非事务查询路由到健康的从库，
+// 写操作、事务、加锁读、非 SELECT 的原生 SQL 以及 WithPrimary 标记的查询仍然走主库
+type ReplicaPlugin struct {
+	name     string
+	policy   string
+	maxLag   time.Duration
+	interval time.Duration
+	replicas []*replica
+	next     atomic.Uint64
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// openReplicaPlugin 按 cfg.Dns 打开从库连接池
+func openReplicaPlugin(name string, cfg ReplicaConfig, maxIdleConns, maxOpenConns int) (*ReplicaPlugin, error) {
+	pools := make([]*sql.DB, 0, len(cfg.Dns))
+	for _, dns := range cfg.Dns {
+		db, err := sql.Open("mysql", dns)
+		if err != nil {
+			for _, pool := range pools {
+				_ = pool.Close()
+			}
+			return nil, err
+		}
+		db.SetMaxIdleConns(maxIdleConns)
+		db.SetMaxOpenConns(maxOpenConns)
+		db.SetConnMaxLifetime(time.Hour)
+		pools = append(pools, db)
+	}
+	return NewReplicaPlugin(name, cfg, pools...), nil
+}
+
+// NewReplicaPlugin 用已经打开的从库连接池创建插件，通过 db.Use 注册；cfg 中的 Dns 只用于标识从库。
+// NewMysqlDB 会按配置自动注册，自己打开 gorm.DB 时才需要调用。Close 停止健康检查并关闭连接池
+func NewReplicaPlugin(name string, cfg ReplicaConfig, pools ...*sql.DB) *ReplicaPlugin {
+	r := &ReplicaPlugin{
+		name:     name,
+		policy:   cfg.GetPolicy(),
+		maxLag:   time.Duration(cfg.MaxLag) * time.Second,
+		interval: cfg.GetCheckInterval(),
+		stopCh:   make(chan struct{}),
+	}
+	for i, db := range pools {
+		rp := &replica{db: db}
+		if i < len(cfg.Dns) {
+			rp.dns = cfg.Dns[i]
+		}
+		rp.lag.Store(-1)
+		r.replicas = append(r.replicas, rp)
+	}
+	r.checkAll()
+	r.wg.Add(1)
+	go r.loop()
+	return r
+}
+
+func (r *ReplicaPlugin) Name() string {
+	return "dbs:replica:" + r.name
+}
+
+func (r *ReplicaPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register(r.Name()+":query", r.route); err != nil {
+		return err
+	}
+	return db.Callback().Row().Before("gorm:row").Register(r.Name()+":row", r.route)
+}
+
+func (r *ReplicaPlugin) route(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	stmt := db.Statement
+	if !isReplicaRead(stmt.Context) {
+		return
+	}
+	if _, inTx := stmt.ConnPool.(gorm.TxCommitter); inTx {
+		return
+	}
+	// 加锁读必须走主库
+	if _, ok := stmt.Clauses["FOR"]; ok {
+		return
+	}
+	// Raw 的 SQL 在回调之前已经生成，只有不加锁的 SELECT 可以走从库
+	if stmt.SQL.Len() > 0 && !isReadOnlySQL(stmt.SQL.String()) {
+		return
+	}
+	if rp := r.pick(); rp != nil {
+		stmt.ConnPool = rp.db
+	}
+}
+
+func isReadOnlySQL(query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(query, "SELECT") {
+		return false
+	}
+	for _, lock := range []string{"FOR UPDATE", "FOR SHARE", "LOCK IN SHARE MODE"} {
+		if strings.Contains(query, lock) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ReplicaPlugin) pick() *replica {
+	n := len(r.replicas)
+	if n == 0 {
+		return nil
+	}
+	if r.policy == ReplicaPolicyLeastConn {
+		var best *replica
+		bestInUse := 0
+		for _, rp := range r.replicas {
+			if !rp.healthy.Load() {
+				continue
+			}
+			inUse := rp.db.Stats().InUse
+			if best == nil || inUse < bestInUse {
+				best, bestInUse = rp, inUse
+			}
+		}
+		return best
+	}
+	healthy := make([]*replica, 0, n)
+	for _, rp := range r.replicas {
+		if rp.healthy.Load() {
+			healthy = append(healthy, rp)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[r.next.Add(1)%uint64(len(healthy))]
+}
+
+func (r *ReplicaPlugin) loop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.checkAll()
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+func (r *ReplicaPlugin) checkAll() {
+	for _, rp := range r.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+		rp.healthy.Store(r.check(ctx, rp))
+		cancel()
+	}
+}
+
+// check ping 失败或复制延迟超过阈值时返回 false。
+// 开启延迟检查但无法获取延迟（未配置复制、复制中断或没有权限）同样视为不健康
+func (r *ReplicaPlugin) check(ctx context.Context, rp *replica) bool {
+	if err := rp.db.PingContext(ctx); err != nil {
+		return false
+	}
+	if r.maxLag <= 0 {
+		return true
+	}
+	lag, ok := replicationLag(ctx, rp.db)
+	if !ok {
+		rp.lag.Store(-1)
+		return false
+	}
+	rp.lag.Store(lag)
+	return time.Duration(lag)*time.Second <= r.maxLag
+}
+
+// replicationLag 读取 Seconds_Behind_Master（8.0.22 之后为 Seconds_Behind_Source）
+func replicationLag(ctx context.Context, db *sql.DB) (int64, bool) {
+	rows, err := db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		rows, err = db.QueryContext(ctx, "SHOW REPLICA STATUS")
+		if err != nil {
+			return 0, false
+		}
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil || !rows.Next() {
+		return 0, false
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, false
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Master" && col != "Seconds_Behind_Source" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, false
+		}
+		lag, err := strconv.ParseInt(string(values[i]), 10, 64)
+		return lag, err == nil
+	}
+	return 0, false
+}
+
+// Stats 从库的健康状态、复制延迟和连接池状态
+func (r *ReplicaPlugin) Stats() []ReplicaStat {
+	stats := make([]ReplicaStat, 0, len(r.replicas))
+	for _, rp := range r.replicas {
+		stats = append(stats, ReplicaStat{
+			Healthy: rp.healthy.Load(),
+			Lag:     rp.lag.Load(),
+			Stats:   rp.db.Stats(),
+		})
+	}
+	return stats
+}
+
+func (r *ReplicaPlugin) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		r.wg.Wait()
+		for _, rp := range r.replicas {
+			_ = rp.db.Close()
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs/sqltest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func newTestReplica(t *testing.T, healthy bool) *replica {
+	db, err := sql.Open("mysql", "root:root@tcp(127.0.0.1:3306)/test")
+	assert.NoError(t, err)
+	rp := &replica{db: db}
+	rp.healthy.Store(healthy)
+	return rp
+}
+
+func TestReplicaPlugin_pick(t *testing.T) {
+	r := &ReplicaPlugin{policy: ReplicaPolicyRoundRobin}
+	assert.Nil(t, r.pick())
+
+	a, b, c := newTestReplica(t, true), newTestReplica(t, false), newTestReplica(t, true)
+	r.replicas = []*replica{a, b, c}
+	seen := map[*replica]int{}
+	for i := 0; i < 10; i++ {
+		seen[r.pick()]++
+	}
+	assert.Equal(t, 0, seen[b])
+	assert.Equal(t, 5, seen[a])
+	assert.Equal(t, 5, seen[c])
+
+	r.policy = ReplicaPolicyLeastConn
+	assert.NotEqual(t, b, r.pick())
+
+	a.healthy.Store(false)
+	c.healthy.Store(false)
+	assert.Nil(t, r.pick())
+}
+
+func TestWithPrimary(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, isForcePrimary(ctx))
+	assert.True(t, isForcePrimary(WithPrimary(ctx)))
+	assert.False(t, isReplicaRead(ctx))
+	assert.True(t, isReplicaRead(WithReplica(ctx)))
+	assert.False(t, isReplicaRead(WithPrimary(WithReplica(ctx))))
+}
+
+type replicaOrder struct {
+	ID int64
+}
+
+func TestReplicaPlugin_Route(t *testing.T) {
+	primary, replica := sqltest.Open(t, nil), sqltest.Open(t, nil)
+	db := primary.Gorm(t)
+	plugin := NewReplicaPlugin("main", ReplicaConfig{}, replica.DB)
+	t.Cleanup(plugin.Close)
+	require.NoError(t, db.Use(plugin))
+
+	ctx := context.Background()
+	replicaCtx := WithReplica(ctx)
+	// 每个用例执行后返回走主库和从库的语句数
+	route := func(fn func()) (int, int) {
+		primary.Reset()
+		replica.Reset()
+		fn()
+		return len(primary.Queries()), len(replica.Queries())
+	}
+	var orders []replicaOrder
+	var id int64
+	cases := []struct {
+		name    string
+		fn      func()
+		replica bool
+	}{
+		{"default", func() { db.WithContext(ctx).Find(&orders) }, false},
+		{"find", func() { db.WithContext(replicaCtx).Find(&orders) }, true},
+		{"count", func() { db.WithContext(replicaCtx).Model(&replicaOrder{}).Count(&id) }, true},
+		{"raw select", func() { db.WithContext(replicaCtx).Raw("SELECT id FROM orders").Scan(&id) }, true},
+		{"row", func() { _ = db.WithContext(replicaCtx).Model(&replicaOrder{}).Select("id").Row().Scan(&id) }, true},
+		{"primary", func() { db.WithContext(WithPrimary(replicaCtx)).Find(&orders) }, false},
+		{"for update", func() {
+			db.WithContext(replicaCtx).Clauses(clause.Locking{Strength: "UPDATE"}).Find(&orders)
+		}, false},
+		{"raw for update", func() { db.WithContext(replicaCtx).Raw("SELECT id FROM orders FOR UPDATE").Scan(&id) }, false},
+		{"raw row for update", func() {
+			_ = db.WithContext(replicaCtx).Raw("select id from orders lock in share mode").Row().Scan(&id)
+		}, false},
+		{"raw write", func() { db.WithContext(replicaCtx).Raw("UPDATE orders SET id = 1").Scan(&id) }, false},
+		{"create", func() { db.WithContext(replicaCtx).Create(&replicaOrder{ID: 1}) }, false},
+		{"transaction", func() {
+			_ = db.WithContext(replicaCtx).Transaction(func(tx *gorm.DB) error {
+				tx.Find(&orders)
+				return tx.Model(&replicaOrder{}).Select("id").Row().Scan(&id)
+			})
+		}, false},
+	}
+	for _, c := range cases {
+		p, r := route(c.fn)
+		if c.replica {
+			assert.Equal(t, 0, p, c.name)
+			assert.Equal(t, 1, r, c.name)
+		} else {
+			assert.NotZero(t, p, c.name)
+			assert.Equal(t, 0, r, c.name)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package dbs
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestInitMysqlDB(t *testing.T) {
 	mysqlCfg := MySqlConfig{
@@ -10,3 +16,15 @@ func TestInitMysqlDB(t *testing.T) {
 	_ = InitMysqlDB(mysqlCfg)
 
 }
+
+func TestNewMysqlDB_Error(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	mysqlCfg := MySqlConfig{
+		MainDns:   "root:root@tcp(127.0.0.1:1)/test?timeout=100ms",
+		ConnRetry: 10,
+	}
+	db, err := NewMysqlDB(ctx, mysqlCfg)
+	assert.Error(t, err)
+	assert.Nil(t, db)
+}
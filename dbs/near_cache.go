@@ -0,0 +1,454 @@
+package dbs
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ville-vv/gutils/uuids"
+	"github.com/ville-vv/gutils/zlog"
+)
+
+const (
+	NearCacheLRU = "lru"
+	NearCacheLFU = "lfu"
+)
+
+type NearCacheConfig struct {
+	Capacity int    `json:"capacity,optional" yaml:"capacity"` // 本地最多缓存的 key 数量，默认 10000
+	Policy   string `json:"policy,optional" yaml:"policy"`     // lru 或 lfu，默认 lru
+	LocalTTL int    `json:"localTtl,optional" yaml:"localTtl"` // 本地缓存时间（秒），默认 60，不会超过 redis 中 key 的剩余 ttl
+	Channel  string `json:"channel,optional" yaml:"channel"`   // 失效通知的频道，默认 dbs:near-cache:invalidate
+}
+
+func (sel *NearCacheConfig) GetCapacity() int {
+	return getIntWithDefault(sel.Capacity, 10000)
+}
+
+func (sel *NearCacheConfig) GetLocalTTL() time.Duration {
+	if sel.LocalTTL <= 0 {
+		return time.Minute
+	}
+	return time.Duration(sel.LocalTTL) * time.Second
+}
+
+func (sel *NearCacheConfig) GetChannel() string {
+	if sel.Channel == "" {
+		sel.Channel = "dbs:near-cache:invalidate"
+	}
+	return sel.Channel
+}
+
+// NearCacheMetrics 各层的命中统计
+type NearCacheMetrics struct {
+	LocalHits   int64
+	LocalMisses int64
+	RedisHits   int64
+	RedisMisses int64
+	Evictions   int64
+	Flushes     int64 // 因为断线清空本地缓存的次数
+	LocalSize   int
+}
+
+type redisSubscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+type invalidateMsg struct {
+	Origin string   `json:"o"`
+	Keys   []string `json:"k"`
+}
+
+// NearCache 两级缓存：进程内 LRU/LFU 在前，redis 在后。
+// Set、Del 通过 redis pub/sub 通知其他实例删除本地缓存；订阅断开期间不使用本地缓存，重连后清空本地缓存
+type NearCache struct {
+	rds    *RedisDB
+	sub    redisSubscriber
+	cfg    NearCacheConfig
+	id     string
+	local  localStore
+	mu     sync.Mutex
+	online atomic.Bool
+	invSeq int64 // 本地删除的次数，读 redis 期间发生过删除时不回填本地，由 mu 保护
+
+	localHits, localMisses, redisHits, redisMisses, evictions, flushes atomic.Int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewNearCache rds 底层的客户端需要支持 Subscribe（*redis.Client、*redis.ClusterClient 等）
+func NewNearCache(rds *RedisDB, cfg NearCacheConfig) (*NearCache, error) {
+	sub, ok := rds.rds.(redisSubscriber)
+	if !ok {
+		return nil, fmt.Errorf("dbs: near cache requires a redis client with Subscribe, got %T", rds.rds)
+	}
+	cfg.Channel = rds.Key(cfg.GetChannel())
+	c := &NearCache{rds: rds, sub: sub, cfg: cfg, id: uuids.UUID(), done: make(chan struct{})}
+	switch cfg.Policy {
+	case "", NearCacheLRU:
+		c.local = newLRUStore(cfg.GetCapacity())
+	case NearCacheLFU:
+		c.local = newLFUStore(cfg.GetCapacity())
+	default:
+		return nil, fmt.Errorf("dbs: unknown near cache policy %s", cfg.Policy)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.listen(ctx)
+	return c, nil
+}
+
+// Get 先查本地，再查 redis，key 不存在时返回 ErrCacheNotFound
+func (c *NearCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if val, ok := c.getLocal(key); ok {
+		c.localHits.Add(1)
+		return val, nil
+	}
+	c.localMisses.Add(1)
+	c.mu.Lock()
+	seq := c.invSeq
+	c.mu.Unlock()
+	pipe := c.rds.rds.Pipeline()
+	get := pipe.Get(ctx, c.rds.Key(key))
+	pttl := pipe.PTTL(ctx, c.rds.Key(key))
+	_, _ = pipe.Exec(ctx)
+	val, err := get.Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.redisMisses.Add(1)
+		return nil, ErrCacheNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.redisHits.Add(1)
+	// 本地缓存不能比 redis 中的 key 活得更久，没有过期时间（-1）时使用 LocalTTL
+	localTTL := c.cfg.GetLocalTTL()
+	if ttl, err := pttl.Result(); err == nil && ttl != -1 && ttl < localTTL {
+		localTTL = ttl
+	}
+	if localTTL > 0 {
+		c.setLocal(key, val, localTTL, seq)
+	}
+	return val, nil
+}
+
+// Set 写入 redis 和本地，并通知其他实例
+func (c *NearCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if err := c.rds.rds.Set(ctx, c.rds.Key(key), val, ttl).Err(); err != nil {
+		return err
+	}
+	localTTL := c.cfg.GetLocalTTL()
+	if ttl > 0 && ttl < localTTL {
+		localTTL = ttl
+	}
+	c.setLocal(key, val, localTTL, -1)
+	return c.publish(ctx, key)
+}
+
+// Del 删除 redis 和本地，并通知其他实例
+func (c *NearCache) Del(ctx context.Context, keys ...string) error {
+	if err := c.rds.rds.Del(ctx, c.rds.keys(keys)...).Err(); err != nil {
+		return err
+	}
+	c.delLocal(keys...)
+	return c.publish(ctx, keys...)
+}
+
+func (c *NearCache) Metrics() NearCacheMetrics {
+	c.mu.Lock()
+	size := c.local.len()
+	c.mu.Unlock()
+	return NearCacheMetrics{
+		LocalHits:   c.localHits.Load(),
+		LocalMisses: c.localMisses.Load(),
+		RedisHits:   c.redisHits.Load(),
+		RedisMisses: c.redisMisses.Load(),
+		Evictions:   c.evictions.Load(),
+		Flushes:     c.flushes.Load(),
+		LocalSize:   size,
+	}
+}
+
+func (c *NearCache) Close() {
+	c.cancel()
+	<-c.done
+}
+
+func (c *NearCache) getLocal(key string) ([]byte, bool) {
+	if !c.online.Load() {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.local.get(key, time.Now())
+}
+
+// setLocal seq 不为 -1 时，只有在 seq 之后没有发生过删除才写入
+func (c *NearCache) setLocal(key string, val []byte, ttl time.Duration, seq int64) {
+	if !c.online.Load() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq >= 0 && seq != c.invSeq {
+		return
+	}
+	if c.local.set(key, val, time.Now().Add(ttl)) {
+		c.evictions.Add(1)
+	}
+}
+
+func (c *NearCache) delLocal(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invSeq++
+	for _, k := range keys {
+		c.local.del(k)
+	}
+}
+
+func (c *NearCache) flushLocal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invSeq++
+	c.local.clear()
+	c.flushes.Add(1)
+}
+
+func (c *NearCache) publish(ctx context.Context, keys ...string) error {
+	data, _ := json.Marshal(invalidateMsg{Origin: c.id, Keys: keys})
+	return c.rds.rds.Publish(ctx, c.cfg.GetChannel(), data).Err()
+}
+
+// listen 订阅失效通知；连接断开时下线本地缓存，重新订阅成功后清空本地缓存再上线
+func (c *NearCache) listen(ctx context.Context) {
+	defer close(c.done)
+	ps := c.sub.Subscribe(ctx, c.cfg.GetChannel())
+	defer ps.Close()
+	// ReceiveTimeout 不会因为 ctx 取消而返回，Close 时需要关闭订阅连接
+	stop := context.AfterFunc(ctx, func() { _ = ps.Close() })
+	defer stop()
+	for {
+		msg, err := ps.ReceiveTimeout(ctx, 30*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// 长时间没有消息时 ping 一下确认连接还在
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if err = ps.Ping(ctx); err == nil {
+					continue
+				}
+			}
+			if c.online.Swap(false) {
+				zlog.Warnf("near cache: subscription lost: %v", err)
+			}
+			c.flushLocal()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				c.flushLocal()
+				c.online.Store(true)
+			}
+		case *redis.Message:
+			var im invalidateMsg
+			if json.Unmarshal([]byte(m.Payload), &im) != nil || im.Origin == c.id {
+				continue
+			}
+			c.delLocal(im.Keys...)
+		}
+	}
+}
+
+// localStore 本地缓存，由 NearCache 加锁
+type localStore interface {
+	get(key string, now time.Time) ([]byte, bool)
+	set(key string, val []byte, expireAt time.Time) (evicted bool)
+	del(key string)
+	clear()
+	len() int
+}
+
+type lruItem struct {
+	key      string
+	val      []byte
+	expireAt time.Time
+}
+
+type lruStore struct {
+	cap   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLRUStore(capacity int) *lruStore {
+	return &lruStore{cap: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (s *lruStore) get(key string, now time.Time) ([]byte, bool) {
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	it := el.Value.(*lruItem)
+	if now.After(it.expireAt) {
+		s.ll.Remove(el)
+		delete(s.items, key)
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return it.val, true
+}
+
+func (s *lruStore) set(key string, val []byte, expireAt time.Time) bool {
+	if el, ok := s.items[key]; ok {
+		it := el.Value.(*lruItem)
+		it.val, it.expireAt = val, expireAt
+		s.ll.MoveToFront(el)
+		return false
+	}
+	s.items[key] = s.ll.PushFront(&lruItem{key: key, val: val, expireAt: expireAt})
+	if s.ll.Len() <= s.cap {
+		return false
+	}
+	last := s.ll.Back()
+	s.ll.Remove(last)
+	delete(s.items, last.Value.(*lruItem).key)
+	return true
+}
+
+func (s *lruStore) del(key string) {
+	if el, ok := s.items[key]; ok {
+		s.ll.Remove(el)
+		delete(s.items, key)
+	}
+}
+
+func (s *lruStore) clear() {
+	s.ll.Init()
+	s.items = make(map[string]*list.Element)
+}
+
+func (s *lruStore) len() int {
+	return s.ll.Len()
+}
+
+type lfuItem struct {
+	key      string
+	val      []byte
+	expireAt time.Time
+	freq     int64
+	seq      int64
+	index    int
+}
+
+// lfuHeap 按访问次数、最近访问顺序排列的小顶堆，堆顶为最先淘汰的 key
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	it := x.(*lfuItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
+
+type lfuStore struct {
+	cap   int
+	seq   int64
+	h     lfuHeap
+	items map[string]*lfuItem
+}
+
+func newLFUStore(capacity int) *lfuStore {
+	return &lfuStore{cap: capacity, items: make(map[string]*lfuItem)}
+}
+
+func (s *lfuStore) touch(it *lfuItem) {
+	s.seq++
+	it.freq++
+	it.seq = s.seq
+	heap.Fix(&s.h, it.index)
+}
+
+func (s *lfuStore) get(key string, now time.Time) ([]byte, bool) {
+	it, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(it.expireAt) {
+		s.del(key)
+		return nil, false
+	}
+	s.touch(it)
+	return it.val, true
+}
+
+func (s *lfuStore) set(key string, val []byte, expireAt time.Time) bool {
+	if it, ok := s.items[key]; ok {
+		it.val, it.expireAt = val, expireAt
+		s.touch(it)
+		return false
+	}
+	evicted := false
+	if len(s.h) >= s.cap {
+		it := heap.Pop(&s.h).(*lfuItem)
+		delete(s.items, it.key)
+		evicted = true
+	}
+	s.seq++
+	it := &lfuItem{key: key, val: val, expireAt: expireAt, freq: 1, seq: s.seq}
+	heap.Push(&s.h, it)
+	s.items[key] = it
+	return evicted
+}
+
+func (s *lfuStore) del(key string) {
+	if it, ok := s.items[key]; ok {
+		heap.Remove(&s.h, it.index)
+		delete(s.items, key)
+	}
+}
+
+func (s *lfuStore) clear() {
+	s.h = nil
+	s.items = make(map[string]*lfuItem)
+}
+
+func (s *lfuStore) len() int {
+	return len(s.h)
+}
@@ -0,0 +1,145 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUStore(t *testing.T) {
+	now := time.Now()
+	s := newLRUStore(2)
+	assert.False(t, s.set("a", []byte("1"), now.Add(time.Minute)))
+	assert.False(t, s.set("b", []byte("2"), now.Add(time.Minute)))
+	_, ok := s.get("a", now)
+	assert.True(t, ok)
+	// b 最久没有访问，被淘汰
+	assert.True(t, s.set("c", []byte("3"), now.Add(time.Minute)))
+	_, ok = s.get("b", now)
+	assert.False(t, ok)
+
+	_, ok = s.get("c", now.Add(2*time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, 1, s.len())
+	s.clear()
+	assert.Equal(t, 0, s.len())
+}
+
+func TestLFUStore(t *testing.T) {
+	now := time.Now()
+	s := newLFUStore(2)
+	s.set("a", []byte("1"), now.Add(time.Minute))
+	s.set("b", []byte("2"), now.Add(time.Minute))
+	s.get("b", now)
+	s.get("b", now)
+	s.get("a", now)
+	// a 访问次数少，被淘汰
+	assert.True(t, s.set("c", []byte("3"), now.Add(time.Minute)))
+	_, ok := s.get("a", now)
+	assert.False(t, ok)
+	v, ok := s.get("b", now)
+	assert.True(t, ok)
+	assert.Equal(t, "2", string(v))
+
+	s.del("b")
+	assert.Equal(t, 1, s.len())
+	_, ok = s.get("c", now.Add(2*time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, 0, s.len())
+}
+
+func newTestNearCache(t *testing.T, rds *RedisDB, cfg NearCacheConfig) *NearCache {
+	c, err := NewNearCache(rds, cfg)
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+	require.Eventually(t, c.online.Load, time.Second, 5*time.Millisecond)
+	return c
+}
+
+func TestNearCache_Invalidate(t *testing.T) {
+	_, rds := newTestRedisDB(t, WithKeyPrefix("app:"))
+	ctx := context.Background()
+	c1 := newTestNearCache(t, rds, NearCacheConfig{})
+	c2 := newTestNearCache(t, rds, NearCacheConfig{})
+
+	require.NoError(t, c1.Set(ctx, "k", []byte("v1"), time.Minute))
+	val, err := c2.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val))
+	val, err = c2.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val))
+	assert.Equal(t, NearCacheMetrics{LocalHits: 1, LocalMisses: 1, RedisHits: 1, Flushes: 1, LocalSize: 1}, c2.Metrics())
+
+	// c1 更新后通过 pub/sub 删除 c2 的本地缓存
+	require.NoError(t, c1.Set(ctx, "k", []byte("v2"), time.Minute))
+	require.Eventually(t, func() bool {
+		val, err := c2.Get(ctx, "k")
+		return err == nil && string(val) == "v2"
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, c1.Del(ctx, "k"))
+	require.Eventually(t, func() bool {
+		_, err := c2.Get(ctx, "k")
+		return err == ErrCacheNotFound
+	}, time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, c2.Metrics().RedisMisses, int64(1))
+}
+
+func TestNearCacheConfig_GetLocalTTL(t *testing.T) {
+	assert.Equal(t, time.Minute, (&NearCacheConfig{}).GetLocalTTL())
+	assert.Equal(t, time.Minute, (&NearCacheConfig{LocalTTL: -1}).GetLocalTTL())
+	assert.Equal(t, 5*time.Second, (&NearCacheConfig{LocalTTL: 5}).GetLocalTTL())
+}
+
+func TestNearCache_LocalTTLCappedByRedis(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	c := newTestNearCache(t, rds, NearCacheConfig{LocalTTL: 60})
+
+	// redis 中只剩 50ms，本地缓存不能保留 1 分钟
+	require.NoError(t, rds.rds.Set(ctx, "k", "v", 50*time.Millisecond).Err())
+	_, err := c.Get(ctx, "k")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), c.Metrics().LocalHits)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), c.Metrics().RedisHits)
+
+	// 没有过期时间的 key 使用 LocalTTL
+	require.NoError(t, rds.rds.Set(ctx, "p", "v", 0).Err())
+	_, _ = c.Get(ctx, "p")
+	_, _ = c.Get(ctx, "p")
+	assert.Equal(t, int64(2), c.Metrics().LocalHits)
+}
+
+func TestNearCache_FlushOnReconnect(t *testing.T) {
+	srv, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	c := newTestNearCache(t, rds, NearCacheConfig{})
+
+	require.NoError(t, c.Set(ctx, "k", []byte("v"), time.Minute))
+	assert.Equal(t, 1, c.Metrics().LocalSize)
+
+	// 断线期间不使用本地缓存，重连后清空
+	srv.Close()
+	require.Eventually(t, func() bool { return !c.online.Load() }, time.Second, 5*time.Millisecond)
+	_, _ = c.Get(ctx, "k")
+	assert.Equal(t, int64(0), c.Metrics().LocalHits)
+	require.NoError(t, srv.Restart())
+	require.Eventually(t, c.online.Load, 5*time.Second, 10*time.Millisecond)
+	m := c.Metrics()
+	assert.GreaterOrEqual(t, m.Flushes, int64(3))
+	assert.Equal(t, 0, m.LocalSize)
+
+	val, err := c.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "v", string(val))
+}
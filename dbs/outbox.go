@@ -0,0 +1,339 @@
+package dbs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ville-vv/gutils/vtask"
+	"github.com/ville-vv/gutils/zlog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OutboxPending = 0
+	OutboxDone    = 1
+	OutboxDead    = 2
+)
+
+var (
+	ErrOutboxNoTx = errors.New("outbox: event must be added inside a transaction")
+)
+
+// OutboxEvent 发件箱事件，和业务数据在同一个事务中写入
+type OutboxEvent struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Topic     string    `gorm:"column:topic;size:128;not null" json:"topic"`
+	Key       string    `gorm:"column:event_key;size:128" json:"key"`
+	Payload   string    `gorm:"column:payload;type:mediumtext" json:"payload"`
+	Status    int8      `gorm:"column:status;not null;default:0;index:idx_outbox_next,priority:1" json:"status"`
+	Attempts  int       `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	NextAt    time.Time `gorm:"column:next_at;index:idx_outbox_next,priority:2" json:"nextAt"`
+	LastError string    `gorm:"column:last_error;size:1024" json:"lastError,omitempty"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+// OutboxPublisher 投递事件，返回错误时按退避时间重试
+type OutboxPublisher interface {
+	Publish(ctx context.Context, ev *OutboxEvent) error
+}
+
+type OutboxPublisherFunc func(ctx context.Context, ev *OutboxEvent) error
+
+func (f OutboxPublisherFunc) Publish(ctx context.Context, ev *OutboxEvent) error {
+	return f(ctx, ev)
+}
+
+type OutboxOption func(o *Outbox)
+
+// WithOutboxTable 发件箱表名，默认 outbox_event
+func WithOutboxTable(table string) OutboxOption {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+// WithOutboxPoll 没有新事件时的轮询间隔，默认 1s
+func WithOutboxPoll(interval time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.pollInterval = interval
+	}
+}
+
+// WithOutboxBatch 每次领取的事件数，默认 100
+func WithOutboxBatch(n int) OutboxOption {
+	return func(o *Outbox) {
+		o.batchSize = n
+	}
+}
+
+// WithOutboxMaxAttempts 最大投递次数，超过后进入死信状态，默认 10
+func WithOutboxMaxAttempts(n int) OutboxOption {
+	return func(o *Outbox) {
+		o.maxAttempts = n
+	}
+}
+
+// WithOutboxLease 领取后的租约时间，租约内没有确认的事件会被重新领取，默认 1m
+func WithOutboxLease(d time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.lease = d
+	}
+}
+
+// WithOutboxWorkPool 投递使用的协程池，默认创建最多 16 个协程的池
+func WithOutboxWorkPool(pool *vtask.DynamicWorkPool) OutboxOption {
+	return func(o *Outbox) {
+		o.pool = pool
+	}
+}
+
+// WithOutboxDeadLetter 事件进入死信状态时的回调
+func WithOutboxDeadLetter(fn func(ctx context.Context, ev *OutboxEvent, err error)) OutboxOption {
+	return func(o *Outbox) {
+		o.deadLetter = fn
+	}
+}
+
+// Outbox 事务发件箱。Add 在业务事务中写入事件，Start 后按批领取待投递的事件交给 publisher，
+// 成功后标记完成，失败按指数退避重试，超过最大次数后标记为死信
+type Outbox struct {
+	db           *gorm.DB
+	publisher    OutboxPublisher
+	table        string
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	lease        time.Duration
+	pool         *vtask.DynamicWorkPool
+	ownPool      bool
+	deadLetter   func(ctx context.Context, ev *OutboxEvent, err error)
+
+	wakeCh   chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewOutbox(db *gorm.DB, publisher OutboxPublisher, opts ...OutboxOption) *Outbox {
+	o := &Outbox{
+		db:           db,
+		publisher:    publisher,
+		table:        "outbox_event",
+		pollInterval: time.Second,
+		batchSize:    100,
+		maxAttempts:  10,
+		lease:        time.Minute,
+		wakeCh:       make(chan struct{}, 1),
+		stopCh:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.pool == nil {
+		o.pool = vtask.NewDynamicWorkPool(vtask.WithMinWorkers(1), vtask.WithMaxWorkers(16))
+		o.ownPool = true
+	}
+	return o
+}
+
+// CreateTable 创建发件箱表
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	return o.db.WithContext(ctx).Table(o.table).AutoMigrate(&OutboxEvent{})
+}
+
+// Add 在 ctx 的事务中写入事件（见 WithTx），payload 为 string、[]byte 时原样保存，其他类型编码为 json。
+// 事务提交后会唤醒投递协程
+func (o *Outbox) Add(ctx context.Context, topic, key string, payload interface{}) error {
+	s := txFromContext(ctx)
+	if s == nil {
+		return ErrOutboxNoTx
+	}
+	if err := o.AddTx(s.db, topic, key, payload); err != nil {
+		return err
+	}
+	AfterCommit(ctx, func(ctx context.Context) { o.wake() })
+	return nil
+}
+
+// AddTx 在指定的 gorm 事务中写入事件
+func (o *Outbox) AddTx(tx *gorm.DB, topic, key string, payload interface{}) error {
+	var data string
+	switch v := payload.(type) {
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	ev := &OutboxEvent{Topic: topic, Key: key, Payload: data, Status: OutboxPending, NextAt: time.Now()}
+	return tx.Table(o.table).Create(ev).Error
+}
+
+func (o *Outbox) wake() {
+	select {
+	case o.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) Start() {
+	o.wg.Add(1)
+	go o.loop()
+}
+
+func (o *Outbox) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopCh)
+		o.wg.Wait()
+		if o.ownPool {
+			o.pool.ReleaseWait()
+		}
+	})
+}
+
+func (o *Outbox) loop() {
+	defer o.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-o.stopCh
+		cancel()
+	}()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-o.stopCh:
+			return
+		case <-o.wakeCh:
+		case <-timer.C:
+		}
+		n, err := o.Dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			zlog.WithContext(ctx).Errorf("outbox dispatch: %v", err)
+		}
+		wait := o.pollInterval
+		if n >= o.batchSize {
+			// 还有积压，立即领取下一批
+			wait = 0
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Dispatch 领取一批到期的事件并提交到协程池投递，返回领取的数量。
+// 领取时使用 FOR UPDATE SKIP LOCKED 并把 next_at 推迟一个租约，多个实例可以同时运行
+func (o *Outbox) Dispatch(ctx context.Context) (int, error) {
+	events, err := o.claim(ctx)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for i := range events {
+		ev := &events[i]
+		wg.Add(1)
+		err = o.pool.Submit(func() {
+			defer wg.Done()
+			o.deliver(ctx, ev)
+		})
+		if err != nil {
+			// 提交失败的事件等租约到期后重新领取
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+	return len(events), err
+}
+
+func (o *Outbox) claim(ctx context.Context) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := o.db.WithContext(WithPrimary(ctx)).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Table(o.table).
+			Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ? AND next_at <= ?", OutboxPending, now).
+			Order("id").Limit(o.batchSize).Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		ids := make([]int64, 0, len(events))
+		for i := range events {
+			ids = append(ids, events[i].ID)
+			events[i].Attempts++
+		}
+		return tx.Table(o.table).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"next_at":  now.Add(o.lease),
+			"attempts": gorm.Expr("attempts + 1"),
+		}).Error
+	})
+	return events, err
+}
+
+func (o *Outbox) deliver(ctx context.Context, ev *OutboxEvent) {
+	pubErr := o.publisher.Publish(ctx, ev)
+	values := map[string]interface{}{}
+	switch {
+	case pubErr == nil:
+		values["status"] = OutboxDone
+		values["last_error"] = ""
+	case ev.Attempts >= o.maxAttempts:
+		values["status"] = OutboxDead
+		values["last_error"] = truncateErr(pubErr)
+	default:
+		values["next_at"] = time.Now().Add(outboxBackoff(ev.Attempts))
+		values["last_error"] = truncateErr(pubErr)
+	}
+	err := o.db.WithContext(ctx).Table(o.table).
+		Where("id = ? AND status = ?", ev.ID, OutboxPending).Updates(values).Error
+	if err != nil {
+		zlog.WithContext(ctx).Errorf("outbox mark event %d: %v", ev.ID, err)
+		return
+	}
+	if pubErr != nil && ev.Attempts >= o.maxAttempts && o.deadLetter != nil {
+		o.deadLetter(ctx, ev, pubErr)
+	}
+}
+
+// Retry 把死信事件重新置为待投递
+func (o *Outbox) Retry(ctx context.Context, ids ...int64) (int64, error) {
+	res := o.db.WithContext(ctx).Table(o.table).Where("id IN ? AND status = ?", ids, OutboxDead).
+		Updates(map[string]interface{}{"status": OutboxPending, "attempts": 0, "next_at": time.Now()})
+	return res.RowsAffected, res.Error
+}
+
+// outboxBackoff 1s、2s、4s ... 最多 10 分钟
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < 10*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 10*time.Minute {
+		d = 10 * time.Minute
+	}
+	return d
+}
+
+func truncateErr(err error) string {
+	s := err.Error()
+	if len(s) > 1024 {
+		s = s[:1024]
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package dbs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamPublisher 把事件 XADD 到 prefix+topic 的 stream 中，maxLen 大于 0 时近似裁剪
+type RedisStreamPublisher struct {
+	rds    redis.Cmdable
+	prefix string
+	maxLen int64
+}
+
+func NewRedisStreamPublisher(rds redis.Cmdable, prefix string, maxLen int64) *RedisStreamPublisher {
+	return &RedisStreamPublisher{rds: rds, prefix: prefix, maxLen: maxLen}
+}
+
+func (p *RedisStreamPublisher) Publish(ctx context.Context, ev *OutboxEvent) error {
+	return p.rds.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.prefix + ev.Topic,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: map[string]interface{}{
+			"id":      strconv.FormatInt(ev.ID, 10),
+			"topic":   ev.Topic,
+			"key":     ev.Key,
+			"payload": ev.Payload,
+		},
+	}).Err()
+}
+
+// WebhookPublisher 以 json POST 事件到 url，非 2xx 响应视为失败
+type WebhookPublisher struct {
+	url     string
+	client  *http.Client
+	headers map[string]string
+}
+
+func NewWebhookPublisher(url string, headers map[string]string, client ...*http.Client) *WebhookPublisher {
+	p := &WebhookPublisher{url: url, headers: headers, client: &http.Client{Timeout: 10 * time.Second}}
+	if len(client) > 0 && client[0] != nil {
+		p.client = client[0]
+	}
+	return p
+}
+
+type webhookBody struct {
+	ID      int64           `json:"id"`
+	Topic   string          `json:"topic"`
+	Key     string          `json:"key"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, ev *OutboxEvent) error {
+	payload := json.RawMessage(ev.Payload)
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(ev.Payload)
+	}
+	data, err := json.Marshal(webhookBody{ID: ev.ID, Topic: ev.Topic, Key: ev.Key, Payload: payload})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.FormatInt(ev.ID, 10))
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook %s: status %d: %s", p.url, resp.StatusCode, body)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
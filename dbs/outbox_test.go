@@ -0,0 +1,183 @@
+package dbs
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs/sqltest"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, outboxBackoff(1))
+	assert.Equal(t, 4*time.Second, outboxBackoff(3))
+	assert.Equal(t, 10*time.Minute, outboxBackoff(30))
+}
+
+func TestOutbox_AddWithoutTx(t *testing.T) {
+	o := NewOutbox(nil, nil)
+	defer o.Stop()
+	assert.ErrorIs(t, o.Add(context.Background(), "order.created", "1", map[string]int{"id": 1}), ErrOutboxNoTx)
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	var got webhookBody
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "7", r.Header.Get("X-Event-Id"))
+		assert.Equal(t, "s", r.Header.Get("X-Sign"))
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.Key == "bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewWebhookPublisher(srv.URL, map[string]string{"X-Sign": "s"})
+	err := p.Publish(context.Background(), &OutboxEvent{ID: 7, Topic: "t", Key: "k", Payload: `{"a":1}`})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(got.Payload))
+
+	err = p.Publish(context.Background(), &OutboxEvent{ID: 7, Topic: "t", Key: "bad", Payload: "plain"})
+	assert.Error(t, err)
+	assert.Equal(t, `"plain"`, string(got.Payload))
+}
+
+// outboxSQL 记录发件箱执行的语句和参数，领取时返回 events
+type outboxSQL struct {
+	mu     sync.Mutex
+	stmts  []string
+	args   [][]interface{}
+	events []OutboxEvent
+}
+
+func (f *outboxSQL) handle(query string, args []driver.NamedValue) sqltest.Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	f.stmts = append(f.stmts, query)
+	f.args = append(f.args, values)
+	if !strings.HasPrefix(query, "SELECT") {
+		return sqltest.Result{RowsAffected: int64(len(f.events))}
+	}
+	res := sqltest.Result{Columns: []string{"id", "topic", "event_key", "payload", "status", "attempts", "next_at"}}
+	for _, ev := range f.events {
+		res.Rows = append(res.Rows, []driver.Value{ev.ID, ev.Topic, ev.Key, ev.Payload, int64(ev.Status), int64(ev.Attempts), ev.NextAt})
+	}
+	return res
+}
+
+// updates 按 id 返回投递后的 UPDATE 参数
+func (f *outboxSQL) updates() map[int64][]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make(map[int64][]interface{})
+	for i, stmt := range f.stmts {
+		if strings.HasSuffix(stmt, "WHERE id = ? AND status = ?") {
+			args := f.args[i]
+			res[args[len(args)-2].(int64)] = args[:len(args)-2]
+		}
+	}
+	return res
+}
+
+func newOutboxTest(t *testing.T, publisher OutboxPublisher, opts ...OutboxOption) (*Outbox, *outboxSQL) {
+	f := &outboxSQL{}
+	o := NewOutbox(sqltest.Open(t, f.handle).Gorm(t), publisher, opts...)
+	t.Cleanup(o.Stop)
+	return o, f
+}
+
+func TestOutbox_Claim(t *testing.T) {
+	o, f := newOutboxTest(t, nil, WithOutboxBatch(2), WithOutboxLease(time.Minute))
+	f.events = []OutboxEvent{{ID: 1, Topic: "t", Attempts: 0}, {ID: 2, Topic: "t", Attempts: 3}}
+
+	start := time.Now()
+	events, err := o.claim(context.Background())
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, 1, events[0].Attempts)
+	assert.Equal(t, 4, events[1].Attempts)
+
+	require.Equal(t, []string{
+		sqltest.Begin,
+		"SELECT * FROM `outbox_event` WHERE status = ? AND next_at <= ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED",
+		"UPDATE `outbox_event` SET `attempts`=attempts + 1,`next_at`=? WHERE id IN (?,?)",
+		sqltest.Commit,
+	}, f.stmts)
+	assert.Equal(t, int64(OutboxPending), f.args[1][0])
+	assert.Equal(t, int64(2), f.args[1][2])
+	// 领取后 next_at 推迟一个租约，租约内其他实例不会重复领取
+	nextAt := f.args[2][0].(time.Time)
+	assert.WithinDuration(t, start.Add(time.Minute), nextAt, time.Second)
+	assert.Equal(t, []interface{}{int64(1), int64(2)}, f.args[2][1:])
+
+	// 没有到期的事件时只执行查询
+	f.stmts, f.args, f.events = nil, nil, nil
+	events, err = o.claim(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	assert.Equal(t, []string{sqltest.Begin, f.stmts[1], sqltest.Commit}, f.stmts)
+}
+
+func TestOutbox_Deliver(t *testing.T) {
+	var dead []int64
+	publisher := OutboxPublisherFunc(func(ctx context.Context, ev *OutboxEvent) error {
+		if ev.Key == "bad" {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	o, f := newOutboxTest(t, publisher, WithOutboxMaxAttempts(3),
+		WithOutboxDeadLetter(func(ctx context.Context, ev *OutboxEvent, err error) {
+			dead = append(dead, ev.ID)
+			assert.EqualError(t, err, "unavailable")
+		}))
+	f.events = []OutboxEvent{
+		{ID: 1, Key: "ok", Attempts: 0},
+		{ID: 2, Key: "bad", Attempts: 1},
+		{ID: 3, Key: "bad", Attempts: 2},
+	}
+
+	start := time.Now()
+	n, err := o.Dispatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// Updates 的列按名称排序：last_error、next_at、status
+	updates := f.updates()
+	require.Len(t, updates, 3)
+	assert.Equal(t, []interface{}{"", int64(OutboxDone)}, updates[1])
+	if assert.Len(t, updates[2], 2) {
+		assert.Equal(t, "unavailable", updates[2][0])
+		assert.WithinDuration(t, start.Add(outboxBackoff(2)), updates[2][1].(time.Time), time.Second)
+	}
+	assert.Equal(t, []interface{}{"unavailable", int64(OutboxDead)}, updates[3])
+	assert.Equal(t, []int64{3}, dead)
+}
+
+func TestOutbox_Retry(t *testing.T) {
+	o, f := newOutboxTest(t, nil)
+	f.events = []OutboxEvent{{ID: 1}, {ID: 2}}
+
+	n, err := o.Retry(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	require.Len(t, f.stmts, 1)
+	assert.Equal(t, "UPDATE `outbox_event` SET `attempts`=?,`next_at`=?,`status`=? WHERE id IN (?,?) AND status = ?", f.stmts[0])
+	args := f.args[0]
+	assert.Equal(t, int64(0), args[0])
+	assert.Equal(t, int64(OutboxPending), args[2])
+	assert.Equal(t, []interface{}{int64(1), int64(2), int64(OutboxDead)}, args[3:])
+}
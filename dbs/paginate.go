@@ -0,0 +1,342 @@
+package dbs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PageModeOffset = "offset"
+	PageModeCursor = "cursor"
+
+	defaultPageSize = 20
+	maxPageSize     = 1000
+)
+
+var (
+	ErrInvalidCursor  = errors.New("invalid page cursor")
+	ErrNoCursorSecret = errors.New("dbs: cursor secret is not set, call SetCursorSecret first")
+
+	errNullCursorValue = errors.New("dbs: cursor column value is NULL")
+
+	_cursorSecret   []byte
+	_cursorSecretMu sync.RWMutex
+)
+
+// SetCursorSecret 设置游标签名密钥，使用 cursor 模式前必须设置。
+// 多实例部署和重启后游标要继续可用，所有实例需要使用相同的密钥
+func SetCursorSecret(secret []byte) {
+	_cursorSecretMu.Lock()
+	defer _cursorSecretMu.Unlock()
+	_cursorSecret = secret
+}
+
+func cursorSecret() []byte {
+	_cursorSecretMu.RLock()
+	defer _cursorSecretMu.RUnlock()
+	return _cursorSecret
+}
+
+// OrderBy 游标分页的排序列，最后一列必须唯一（例如主键），否则会漏数据。
+// 排序列必须是 NOT NULL，遇到 NULL 值时生成游标会返回错误
+type OrderBy struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+type PageReq struct {
+	Mode      string    `json:"mode,optional"` // offset 或 cursor，默认 offset
+	Page      int       `json:"page,optional"`
+	PageSize  int       `json:"pageSize,optional"`
+	WithTotal bool      `json:"withTotal,optional"` // offset 模式下是否查询总数
+	Cursor    string    `json:"cursor,optional"`    // cursor 模式下上一页返回的 nextCursor，第一页为空
+	Orders    []OrderBy `json:"-"`                  // cursor 模式下的排序列
+}
+
+func (sel *PageReq) GetPage() int {
+	if sel.Page <= 0 {
+		sel.Page = 1
+	}
+	return sel.Page
+}
+
+func (sel *PageReq) GetPageSize() int {
+	if sel.PageSize <= 0 {
+		sel.PageSize = defaultPageSize
+	}
+	if sel.PageSize > maxPageSize {
+		sel.PageSize = maxPageSize
+	}
+	return sel.PageSize
+}
+
+type PageResp[T any] struct {
+	List       []T    `json:"list"`
+	Total      int64  `json:"total,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"pageSize"`
+	HasNext    bool   `json:"hasNext"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Paginate 分页查询，query 需要已经指定 Model/Table 和过滤条件。
+// offset 模式按 Page/PageSize 查询；cursor 模式按 Orders 做 keyset 分页，游标经过签名防篡改
+func Paginate[T any](ctx context.Context, query *gorm.DB, req PageReq) (*PageResp[T], error) {
+	query = query.WithContext(ctx)
+	size := req.GetPageSize()
+	resp := &PageResp[T]{PageSize: size}
+	var list []T
+	if req.Mode == PageModeCursor {
+		if len(req.Orders) == 0 {
+			return nil, fmt.Errorf("dbs: cursor pagination requires orders")
+		}
+		if len(cursorSecret()) == 0 {
+			return nil, ErrNoCursorSecret
+		}
+		if req.Cursor != "" {
+			values, err := decodeCursor(req.Cursor, req.Orders)
+			if err != nil {
+				return nil, err
+			}
+			expr, args := keysetWhere(req.Orders, values)
+			query = query.Where(expr, args...)
+		}
+		for _, o := range req.Orders {
+			query = query.Order(orderExpr(o))
+		}
+		if err := query.Limit(size + 1).Find(&list).Error; err != nil {
+			return nil, err
+		}
+		if len(list) > size {
+			list = list[:size]
+			resp.HasNext = true
+			cursor, err := encodeCursor(query, list[size-1], req.Orders)
+			if err != nil {
+				return nil, err
+			}
+			resp.NextCursor = cursor
+		}
+		resp.List = list
+		return resp, nil
+	}
+
+	page := req.GetPage()
+	resp.Page = page
+	if req.WithTotal {
+		if err := query.Session(&gorm.Session{}).Count(&resp.Total).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := query.Offset((page - 1) * size).Limit(size + 1).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) > size {
+		list = list[:size]
+		resp.HasNext = true
+	}
+	resp.List = list
+	return resp, nil
+}
+
+func quoteColumn(col string) string {
+	parts := strings.Split(col, ".")
+	for i, p := range parts {
+		parts[i] = "`" + strings.Trim(p, "`") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+func orderExpr(o OrderBy) string {
+	if o.Desc {
+		return quoteColumn(o.Column) + " DESC"
+	}
+	return quoteColumn(o.Column) + " ASC"
+}
+
+// keysetWhere 生成多列 keyset 条件：(a > ?) OR (a = ? AND b > ?) OR ...，降序列使用 <
+func keysetWhere(orders []OrderBy, values []interface{}) (string, []interface{}) {
+	var (
+		ors  []string
+		args []interface{}
+	)
+	for i, o := range orders {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, quoteColumn(orders[j].Column)+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if o.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, quoteColumn(o.Column)+op)
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func orderSignature(orders []OrderBy) string {
+	parts := make([]string, 0, len(orders))
+	for _, o := range orders {
+		if o.Desc {
+			parts = append(parts, o.Column+":desc")
+		} else {
+			parts = append(parts, o.Column)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cursorValue 带类型的游标值，解码后还原为原始类型再交给驱动
+type cursorValue struct {
+	K string `json:"k"`
+	V string `json:"v"`
+}
+
+type cursorPayload struct {
+	O string        `json:"o"`
+	V []cursorValue `json:"v"`
+}
+
+func toCursorValue(v interface{}) (cursorValue, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	// sql.NullXxx 等类型取出驱动值
+	if rv.IsValid() && rv.Kind() != reflect.Ptr {
+		if valuer, ok := rv.Interface().(driver.Valuer); ok {
+			dv, err := valuer.Value()
+			if err != nil {
+				return cursorValue{}, err
+			}
+			rv = reflect.ValueOf(dv)
+		}
+	}
+	if !rv.IsValid() || rv.Kind() == reflect.Ptr {
+		// col < NULL 永远不成立，NULL 值无法作为 keyset 条件
+		return cursorValue{}, errNullCursorValue
+	}
+	if t, ok := rv.Interface().(time.Time); ok {
+		return cursorValue{K: "t", V: t.Format(time.RFC3339Nano)}, nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cursorValue{K: "i", V: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cursorValue{K: "u", V: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return cursorValue{K: "f", V: strconv.FormatFloat(rv.Float(), 'g', -1, 64)}, nil
+	case reflect.String:
+		return cursorValue{K: "s", V: rv.String()}, nil
+	case reflect.Bool:
+		return cursorValue{K: "b", V: strconv.FormatBool(rv.Bool())}, nil
+	default:
+		return cursorValue{}, fmt.Errorf("dbs: unsupported cursor column type %s", rv.Type())
+	}
+}
+
+func (c cursorValue) value() (interface{}, error) {
+	switch c.K {
+	case "t":
+		return time.Parse(time.RFC3339Nano, c.V)
+	case "i":
+		return strconv.ParseInt(c.V, 10, 64)
+	case "u":
+		return strconv.ParseUint(c.V, 10, 64)
+	case "f":
+		return strconv.ParseFloat(c.V, 64)
+	case "s":
+		return c.V, nil
+	case "b":
+		return strconv.ParseBool(c.V)
+	default:
+		return nil, ErrInvalidCursor
+	}
+}
+
+func signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, cursorSecret())
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// encodeCursor 从最后一行取出排序列的值，生成 base64(payload).base64(hmac)
+func encodeCursor(query *gorm.DB, row interface{}, orders []OrderBy) (string, error) {
+	stmt := &gorm.Statement{DB: query}
+	if err := stmt.Parse(row); err != nil {
+		return "", err
+	}
+	rv := reflect.ValueOf(row)
+	payload := cursorPayload{O: orderSignature(orders)}
+	for _, o := range orders {
+		col := o.Column
+		if idx := strings.LastIndex(col, "."); idx >= 0 {
+			col = col[idx+1:]
+		}
+		field := stmt.Schema.LookUpField(strings.Trim(col, "`"))
+		if field == nil {
+			return "", fmt.Errorf("dbs: cursor column %s not found in %s", o.Column, stmt.Schema.Name)
+		}
+		v, _ := field.ValueOf(context.Background(), rv)
+		cv, err := toCursorValue(v)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", err, o.Column)
+		}
+		payload.V = append(payload.V, cv)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(data) + "." + enc.EncodeToString(signCursor(data)), nil
+}
+
+func decodeCursor(cursor string, orders []OrderBy) ([]interface{}, error) {
+	enc := base64.RawURLEncoding
+	dataStr, sigStr, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	data, err := enc.DecodeString(dataStr)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(sigStr)
+	if err != nil || !hmac.Equal(sig, signCursor(data)) {
+		return nil, ErrInvalidCursor
+	}
+	var payload cursorPayload
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err = dec.Decode(&payload); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if payload.O != orderSignature(orders) || len(payload.V) != len(orders) {
+		return nil, ErrInvalidCursor
+	}
+	values := make([]interface{}, 0, len(payload.V))
+	for _, cv := range payload.V {
+		v, err := cv.value()
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
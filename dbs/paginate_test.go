@@ -0,0 +1,93 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type pageUser struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+func TestCursor_EncodeDecode(t *testing.T) {
+	// 只用于解析 schema，不会建立连接
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true})
+	assert.NoError(t, err)
+	SetCursorSecret([]byte("secret"))
+	orders := []OrderBy{{Column: "created_at", Desc: true}, {Column: "id"}}
+	now := time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC)
+	row := pageUser{ID: 1 << 60, Name: "a", CreatedAt: now}
+
+	cursor, err := encodeCursor(db, row, orders)
+	assert.NoError(t, err)
+
+	values, err := decodeCursor(cursor, orders)
+	assert.NoError(t, err)
+	if assert.Len(t, values, 2) {
+		assert.True(t, now.Equal(values[0].(time.Time)))
+		assert.Equal(t, int64(1<<60), values[1])
+	}
+
+	// 排序变化或被篡改都要拒绝
+	_, err = decodeCursor(cursor, []OrderBy{{Column: "id"}})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	data, sig, _ := strings.Cut(cursor, ".")
+	_, err = decodeCursor(data+"x."+sig, orders)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = decodeCursor("bad", orders)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestKeysetWhere(t *testing.T) {
+	expr, args := keysetWhere([]OrderBy{{Column: "created_at", Desc: true}, {Column: "u.id"}}, []interface{}{"t", 3})
+	assert.Equal(t, "((`created_at` < ?) OR (`created_at` = ? AND `u`.`id` > ?))", expr)
+	assert.Equal(t, []interface{}{"t", "t", 3}, args)
+}
+
+type pageNullUser struct {
+	ID        int64
+	DeletedAt *time.Time
+	Score     sql.NullInt64
+}
+
+func TestCursor_Null(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true})
+	assert.NoError(t, err)
+	SetCursorSecret([]byte("secret"))
+
+	row := pageNullUser{ID: 1}
+	_, err = encodeCursor(db, row, []OrderBy{{Column: "deleted_at"}, {Column: "id"}})
+	assert.ErrorIs(t, err, errNullCursorValue)
+	_, err = encodeCursor(db, row, []OrderBy{{Column: "score"}, {Column: "id"}})
+	assert.ErrorIs(t, err, errNullCursorValue)
+
+	row.Score = sql.NullInt64{Int64: 5, Valid: true}
+	cursor, err := encodeCursor(db, row, []OrderBy{{Column: "score"}, {Column: "id"}})
+	assert.NoError(t, err)
+	values, err := decodeCursor(cursor, []OrderBy{{Column: "score"}, {Column: "id"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(5), int64(1)}, values)
+}
+
+func TestPaginate_NoCursorSecret(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DisableAutomaticPing: true, DryRun: true})
+	assert.NoError(t, err)
+	old := cursorSecret()
+	SetCursorSecret(nil)
+	defer SetCursorSecret(old)
+
+	_, err = Paginate[pageUser](context.Background(), db.Model(&pageUser{}), PageReq{Mode: PageModeCursor, Orders: []OrderBy{{Column: "id"}}})
+	assert.ErrorIs(t, err, ErrNoCursorSecret)
+}
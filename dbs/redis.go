@@ -2,16 +2,37 @@ package dbs
 
 import (
 	"context"
-	"crypto/tls"
+	"fmt"
 	"github.com/redis/go-redis/v9"
 	"time"
 )
 
 type RedisConfig struct {
-	Addr     string `json:"addr"`
-	Password string `json:"password"`
-	DB       int    `json:"db"`
-	Tls      bool   `json:"tls"`
+	Mode     string   `json:"mode,optional" yaml:"mode"` // standalone、sentinel 或 cluster，默认 standalone
+	Addr     string   `json:"addr,optional" yaml:"addr"`
+	Addrs    []string `json:"addrs,optional" yaml:"addrs"` // sentinel 模式为哨兵地址，cluster 模式为节点地址
+	Username string   `json:"username,optional" yaml:"username"`
+	Password string   `json:"password,optional" yaml:"password"`
+	DB       int      `json:"db,optional" yaml:"db"` // cluster 模式不支持
+
+	MasterName       string `json:"masterName,optional" yaml:"masterName"`
+	SentinelUsername string `json:"sentinelUsername,optional" yaml:"sentinelUsername"`
+	SentinelPassword string `json:"sentinelPassword,optional" yaml:"sentinelPassword"`
+
+	Tls           bool   `json:"tls,optional" yaml:"tls"`
+	TlsCAFile     string `json:"tlsCaFile,optional" yaml:"tlsCaFile"`         // 为空时使用系统根证书
+	TlsCertFile   string `json:"tlsCertFile,optional" yaml:"tlsCertFile"`     // 客户端证书，需要和 TlsKeyFile 一起配置
+	TlsKeyFile    string `json:"tlsKeyFile,optional" yaml:"tlsKeyFile"`       //
+	TlsServerName string `json:"tlsServerName,optional" yaml:"tlsServerName"` // 为空时使用连接地址的主机名
+	TlsSkipVerify bool   `json:"tlsSkipVerify,optional" yaml:"tlsSkipVerify"` // 不校验服务端证书，只用于测试环境
+
+	PoolSize     int `json:"poolSize,optional" yaml:"poolSize"`         // 默认 10 * CPU 数
+	MinIdleConns int `json:"minIdleConns,optional" yaml:"minIdleConns"` // 默认 5
+	MaxRetries   int `json:"maxRetries,optional" yaml:"maxRetries"`     // 默认 5，-1 表示不重试
+	DialTimeout  int `json:"dialTimeout,optional" yaml:"dialTimeout"`   // 连接超时（毫秒），默认 5000
+	ReadTimeout  int `json:"readTimeout,optional" yaml:"readTimeout"`   // 读超时（毫秒），默认 3000，-1 表示不超时
+	WriteTimeout int `json:"writeTimeout,optional" yaml:"writeTimeout"` // 写超时（毫秒），默认同 ReadTimeout，-1 表示不超时
+	PoolTimeout  int `json:"poolTimeout,optional" yaml:"poolTimeout"`   // 等待连接池的超时（毫秒），默认 ReadTimeout + 1000
 }
 
 type IRedisDB interface {
@@ -20,35 +41,102 @@ type IRedisDB interface {
 }
 
 type RedisDB struct {
-	rds IRedisDB
+	rds    IRedisDB
+	prefix string
 }
 
-func InitRedisDB(rds IRedisDB) *RedisDB {
-	return &RedisDB{rds: rds}
+type RedisDBOption func(db *RedisDB)
+
+// WithKeyPrefix 所有 key 加上前缀（如 svc:env:），多个服务共用一个 redis 时避免冲突。
+// 基于 RedisDB 的 Cached、NearCache、BloomFilter、Leaderboard 等也会加上前缀
+func WithKeyPrefix(prefix string) RedisDBOption {
+	return func(db *RedisDB) {
+		db.prefix = prefix
+	}
 }
 
-func MustRedisClient(cfg RedisConfig) *redis.Client {
-	var tlsConfig *tls.Config
-	if cfg.Tls {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+func InitRedisDB(rds IRedisDB, opts ...RedisDBOption) *RedisDB {
+	db := &RedisDB{rds: rds}
+	for _, opt := range opts {
+		opt(db)
+	}
+	_defaultRedisMu.Lock()
+	if _defaultRedis == nil {
+		_defaultRedis = db
 	}
-	rds := redis.NewClient(&redis.Options{
-		Addr:         cfg.Addr,
-		Password:     cfg.Password,
-		DB:           cfg.DB,
-		MaxRetries:   5,
-		MinIdleConns: 5,
-		TLSConfig:    tlsConfig,
-	})
-	_, err := rds.Ping(context.Background()).Result()
+	_defaultRedisMu.Unlock()
+	return db
+}
+
+// MustRedisClient 创建 standalone 或 sentinel 模式的客户端，连接失败时 panic。cluster 模式使用 NewRedisClient
+func MustRedisClient(cfg RedisConfig) *redis.Client {
+	rds, err := NewRedisClient(context.Background(), cfg)
 	if err != nil {
 		panic(err)
 	}
-	return rds
+	client, ok := rds.(*redis.Client)
+	if !ok {
+		_ = rds.Close()
+		panic(fmt.Sprintf("dbs: MustRedisClient does not support redis mode %s, use NewRedisClient", cfg.Mode))
+	}
+	return client
+}
+
+// Key 返回加上前缀后的 key
+func (sel *RedisDB) Key(key string) string {
+	return sel.prefix + key
+}
+
+func (sel *RedisDB) keys(keys []string) []string {
+	if sel.prefix == "" {
+		return keys
+	}
+	res := make([]string, len(keys))
+	for i, k := range keys {
+		res[i] = sel.prefix + k
+	}
+	return res
+}
+
+// prefixPairs 给 MSet 形式的参数中的 key 加上前缀，支持 k1, v1, k2, v2、[]string 和 map
+func (sel *RedisDB) prefixPairs(values []interface{}) []interface{} {
+	if sel.prefix == "" {
+		return values
+	}
+	if len(values) == 1 {
+		switch arg := values[0].(type) {
+		case []string:
+			values = make([]interface{}, len(arg))
+			for i, v := range arg {
+				values[i] = v
+			}
+		case []interface{}:
+			values = arg
+		case map[string]interface{}:
+			res := make(map[string]interface{}, len(arg))
+			for k, v := range arg {
+				res[sel.prefix+k] = v
+			}
+			return []interface{}{res}
+		case map[string]string:
+			res := make(map[string]string, len(arg))
+			for k, v := range arg {
+				res[sel.prefix+k] = v
+			}
+			return []interface{}{res}
+		}
+	}
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		if k, ok := v.(string); ok && i%2 == 0 {
+			v = sel.prefix + k
+		}
+		res[i] = v
+	}
+	return res
 }
 
+// ZAddWithMaxSizeEx 添加成员，只保留分数最高的 maxSize 个，并设置过期时间（秒）
 func (sel *RedisDB) ZAddWithMaxSizeEx(key string, score int64, val string, maxSize int, exp int) error {
 	luaScript := `
 redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
@@ -62,6 +150,7 @@ return redis.call("EXPIRE", KEYS[1], ARGV[4])
 	return err
 }
 
+// LPushWithMaxSizeEx 从左边插入，只保留最新的 maxSize 个，并设置过期时间（秒）
 func (sel *RedisDB) LPushWithMaxSizeEx(key string, val string, maxSize int, exp int) error {
 	luaScript := `
 redis.call("LPUSH", KEYS[1], ARGV[1])
@@ -101,169 +190,169 @@ return redis.call("EXPIRE", KEYS[1], ARGV[#ARGV])
 }
 
 func (sel *RedisDB) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
-	return sel.rds.Eval(context.Background(), script, keys, args...).Result()
+	return sel.rds.Eval(context.Background(), script, sel.keys(keys), args...).Result()
 }
 
 func (sel *RedisDB) HSet(key, field, value string) (int64, error) {
-	return sel.rds.HSet(context.Background(), key, field, value).Result()
+	return sel.rds.HSet(context.Background(), sel.Key(key), field, value).Result()
 }
 
 // HDel 删除哈希中的指定字段
 func (sel *RedisDB) HDel(key string, fields ...string) (int64, error) {
-	return sel.rds.HDel(context.Background(), key, fields...).Result()
+	return sel.rds.HDel(context.Background(), sel.Key(key), fields...).Result()
 }
 
 // HExists 检查哈希中的字段是否存在
 func (sel *RedisDB) HExists(key, field string) (bool, error) {
-	return sel.rds.HExists(context.Background(), key, field).Result()
+	return sel.rds.HExists(context.Background(), sel.Key(key), field).Result()
 }
 
 // HGet 获取哈希中指定字段的值
 func (sel *RedisDB) HGet(key, field string) (string, error) {
-	return sel.rds.HGet(context.Background(), key, field).Result()
+	return sel.rds.HGet(context.Background(), sel.Key(key), field).Result()
 }
 
 // HGetAll 获取哈希中的所有键值对
 func (sel *RedisDB) HGetAll(key string) (map[string]string, error) {
-	return sel.rds.HGetAll(context.Background(), key).Result()
+	return sel.rds.HGetAll(context.Background(), sel.Key(key)).Result()
 }
 
 // HIncrBy 将哈希中的字段值增加指定整数
 func (sel *RedisDB) HIncrBy(key, field string, incr int64) (int64, error) {
-	return sel.rds.HIncrBy(context.Background(), key, field, incr).Result()
+	return sel.rds.HIncrBy(context.Background(), sel.Key(key), field, incr).Result()
 }
 
 // HIncrByFloat 将哈希中的字段值增加指定浮点数
 func (sel *RedisDB) HIncrByFloat(key, field string, incr float64) (float64, error) {
-	return sel.rds.HIncrByFloat(context.Background(), key, field, incr).Result()
+	return sel.rds.HIncrByFloat(context.Background(), sel.Key(key), field, incr).Result()
 }
 
 // HKeys 获取哈希中的所有字段名
 func (sel *RedisDB) HKeys(key string) ([]string, error) {
-	return sel.rds.HKeys(context.Background(), key).Result()
+	return sel.rds.HKeys(context.Background(), sel.Key(key)).Result()
 }
 
 // HLen 获取哈希中的字段数
 func (sel *RedisDB) HLen(key string) (int64, error) {
-	return sel.rds.HLen(context.Background(), key).Result()
+	return sel.rds.HLen(context.Background(), sel.Key(key)).Result()
 }
 
 // HMGet 获取哈希中的多个字段值
 func (sel *RedisDB) HMGet(key string, fields ...string) ([]interface{}, error) {
-	return sel.rds.HMGet(context.Background(), key, fields...).Result()
+	return sel.rds.HMGet(context.Background(), sel.Key(key), fields...).Result()
 }
 
 // HMSet 批量设置哈希中的字段值（已废弃，可以直接使用 HSet）
 func (sel *RedisDB) HMSet(key string, values ...interface{}) (int64, error) {
-	return sel.rds.HSet(context.Background(), key, values...).Result()
+	return sel.rds.HSet(context.Background(), sel.Key(key), values...).Result()
 }
 
 // HSetNX 如果字段不存在则设置字段的值
 func (sel *RedisDB) HSetNX(key, field string, value interface{}) (bool, error) {
-	return sel.rds.HSetNX(context.Background(), key, field, value).Result()
+	return sel.rds.HSetNX(context.Background(), sel.Key(key), field, value).Result()
 }
 
 // HScan 通过游标扫描哈希中的字段及值
 func (sel *RedisDB) HScan(key string, cursor uint64, match string, count int64) ([]string, uint64, error) {
-	return sel.rds.HScan(context.Background(), key, cursor, match, count).Result()
+	return sel.rds.HScan(context.Background(), sel.Key(key), cursor, match, count).Result()
 }
 
 // HScanNoValues 通过游标扫描哈希中的字段，不返回值
 func (sel *RedisDB) HScanNoValues(key string, cursor uint64, match string, count int64) ([]string, uint64, error) {
-	return sel.rds.HScanNoValues(context.Background(), key, cursor, match, count).Result()
+	return sel.rds.HScanNoValues(context.Background(), sel.Key(key), cursor, match, count).Result()
 }
 
 // Append 向字符串值追加内容
 func (sel *RedisDB) Append(key, value string) (int64, error) {
-	return sel.rds.Append(context.Background(), key, value).Result()
+	return sel.rds.Append(context.Background(), sel.Key(key), value).Result()
 }
 
 // Decr 将字符串的值减少 1
 func (sel *RedisDB) Decr(key string) (int64, error) {
-	return sel.rds.Decr(context.Background(), key).Result()
+	return sel.rds.Decr(context.Background(), sel.Key(key)).Result()
 }
 
 // DecrBy 将字符串的值减少指定的整数
 func (sel *RedisDB) DecrBy(key string, decrement int64) (int64, error) {
-	return sel.rds.DecrBy(context.Background(), key, decrement).Result()
+	return sel.rds.DecrBy(context.Background(), sel.Key(key), decrement).Result()
 }
 
 // Get 获取字符串值
 func (sel *RedisDB) Get(key string) (string, error) {
-	return sel.rds.Get(context.Background(), key).Result()
+	return sel.rds.Get(context.Background(), sel.Key(key)).Result()
 }
 
 // GetRange 获取字符串的指定范围
 func (sel *RedisDB) GetRange(key string, start, end int64) (string, error) {
-	return sel.rds.GetRange(context.Background(), key, start, end).Result()
+	return sel.rds.GetRange(context.Background(), sel.Key(key), start, end).Result()
 }
 
 // GetSet 设置字符串值并返回旧值
 func (sel *RedisDB) GetSet(key string, value interface{}) (string, error) {
-	return sel.rds.GetSet(context.Background(), key, value).Result()
+	return sel.rds.GetSet(context.Background(), sel.Key(key), value).Result()
 }
 
 // GetEx 获取字符串值并设置过期时间
 func (sel *RedisDB) GetEx(key string, expiration time.Duration) (string, error) {
-	return sel.rds.GetEx(context.Background(), key, expiration).Result()
+	return sel.rds.GetEx(context.Background(), sel.Key(key), expiration).Result()
 }
 
 // GetDel 获取并删除字符串值
 func (sel *RedisDB) GetDel(key string) (string, error) {
-	return sel.rds.GetDel(context.Background(), key).Result()
+	return sel.rds.GetDel(context.Background(), sel.Key(key)).Result()
 }
 
 // Incr 将字符串的值增加 1
 func (sel *RedisDB) Incr(key string) (int64, error) {
-	return sel.rds.Incr(context.Background(), key).Result()
+	return sel.rds.Incr(context.Background(), sel.Key(key)).Result()
 }
 
 // IncrBy 将字符串的值增加指定的整数
 func (sel *RedisDB) IncrBy(key string, value int64) (int64, error) {
-	return sel.rds.IncrBy(context.Background(), key, value).Result()
+	return sel.rds.IncrBy(context.Background(), sel.Key(key), value).Result()
 }
 
 // IncrByFloat 将字符串的值增加指定的浮点数
 func (sel *RedisDB) IncrByFloat(key string, value float64) (float64, error) {
-	return sel.rds.IncrByFloat(context.Background(), key, value).Result()
+	return sel.rds.IncrByFloat(context.Background(), sel.Key(key), value).Result()
 }
 
 // MGet 获取多个字符串值
 func (sel *RedisDB) MGet(keys ...string) ([]interface{}, error) {
-	return sel.rds.MGet(context.Background(), keys...).Result()
+	return sel.rds.MGet(context.Background(), sel.keys(keys)...).Result()
 }
 
 // MSet 设置多个键值对
 func (sel *RedisDB) MSet(values ...interface{}) (string, error) {
-	return sel.rds.MSet(context.Background(), values...).Result()
+	return sel.rds.MSet(context.Background(), sel.prefixPairs(values)...).Result()
 }
 
 // MSetNX 设置多个键值对，只有在所有键都不存在时成功
 func (sel *RedisDB) MSetNX(values ...interface{}) (bool, error) {
-	return sel.rds.MSetNX(context.Background(), values...).Result()
+	return sel.rds.MSetNX(context.Background(), sel.prefixPairs(values)...).Result()
 }
 
 // SetEx 设置字符串值并指定过期时间
 func (sel *RedisDB) SetEx(key string, value interface{}, exp int) (string, error) {
-	return sel.rds.SetEx(context.Background(), key, value, time.Duration(exp)*time.Second).Result()
+	return sel.rds.SetEx(context.Background(), sel.Key(key), value, time.Duration(exp)*time.Second).Result()
 }
 
 // SetNX 如果键不存在则设置字符串值
 func (sel *RedisDB) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
-	return sel.rds.SetNX(context.Background(), key, value, expiration).Result()
+	return sel.rds.SetNX(context.Background(), sel.Key(key), value, expiration).Result()
 }
 
 // SetXX 如果键存在则设置字符串值
 func (sel *RedisDB) SetXX(key string, value interface{}, expiration time.Duration) (bool, error) {
-	return sel.rds.SetXX(context.Background(), key, value, expiration).Result()
+	return sel.rds.SetXX(context.Background(), sel.Key(key), value, expiration).Result()
 }
 
 // SetRange 设置字符串的某个偏移位置的值
 func (sel *RedisDB) SetRange(key string, offset int64, value string) (int64, error) {
-	return sel.rds.SetRange(context.Background(), key, offset, value).Result()
+	return sel.rds.SetRange(context.Background(), sel.Key(key), offset, value).Result()
 }
 
 // StrLen 获取字符串值的长度
 func (sel *RedisDB) StrLen(key string) (int64, error) {
-	return sel.rds.StrLen(context.Background(), key).Result()
+	return sel.rds.StrLen(context.Background(), sel.Key(key)).Result()
 }
@@ -0,0 +1,195 @@
+package dbs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+func (sel *RedisConfig) GetMode() string {
+	if sel.Mode == "" {
+		sel.Mode = RedisModeStandalone
+	}
+	return sel.Mode
+}
+
+func (sel *RedisConfig) GetMinIdleConns() int {
+	return getIntWithDefault(sel.MinIdleConns, 5)
+}
+
+func (sel *RedisConfig) GetMaxRetries() int {
+	return getIntWithDefault(sel.MaxRetries, 5)
+}
+
+func (sel *RedisConfig) GetDialTimeout() time.Duration {
+	if sel.DialTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(sel.DialTimeout) * time.Millisecond
+}
+
+// GetReadTimeout 小于 0 时返回 -1，go-redis 按不超时处理
+func (sel *RedisConfig) GetReadTimeout() time.Duration {
+	return redisTimeout(sel.ReadTimeout, 3*time.Second)
+}
+
+func (sel *RedisConfig) GetWriteTimeout() time.Duration {
+	return redisTimeout(sel.WriteTimeout, sel.GetReadTimeout())
+}
+
+func (sel *RedisConfig) GetPoolTimeout() time.Duration {
+	if sel.PoolTimeout > 0 {
+		return time.Duration(sel.PoolTimeout) * time.Millisecond
+	}
+	if read := sel.GetReadTimeout(); read > 0 {
+		return read + time.Second
+	}
+	return 30 * time.Second
+}
+
+func redisTimeout(ms int, d time.Duration) time.Duration {
+	switch {
+	case ms < 0:
+		return -1
+	case ms == 0:
+		return d
+	default:
+		return time.Duration(ms) * time.Millisecond
+	}
+}
+
+// addrs standalone 模式优先使用 Addr
+func (sel *RedisConfig) addrs() []string {
+	if sel.Addr != "" {
+		return append([]string{sel.Addr}, sel.Addrs...)
+	}
+	return sel.Addrs
+}
+
+// TLSConfig Tls 为 false 时返回 nil
+func (sel *RedisConfig) TLSConfig() (*tls.Config, error) {
+	if !sel.Tls {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         sel.TlsServerName,
+		InsecureSkipVerify: sel.TlsSkipVerify,
+	}
+	if sel.TlsCAFile != "" {
+		pem, err := os.ReadFile(sel.TlsCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis tls: read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis tls: no certificate found in %s", sel.TlsCAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if sel.TlsCertFile != "" || sel.TlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(sel.TlsCertFile, sel.TlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis tls: load client cert: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// NewRedisClient 按 Mode 创建客户端并 ping，失败时关闭客户端并返回错误
+func NewRedisClient(ctx context.Context, cfg RedisConfig) (redis.UniversalClient, error) {
+	rds, err := newUniversalClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, cfg.GetDialTimeout())
+	defer cancel()
+	if err = rds.Ping(pingCtx).Err(); err != nil {
+		_ = rds.Close()
+		return nil, fmt.Errorf("redis %s ping: %w", cfg.GetMode(), err)
+	}
+	return rds, nil
+}
+
+func newUniversalClient(cfg *RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	addrs := cfg.addrs()
+	switch cfg.GetMode() {
+	case RedisModeStandalone:
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("redis: addr is required")
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:         addrs[0],
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.GetMinIdleConns(),
+			MaxRetries:   cfg.GetMaxRetries(),
+			DialTimeout:  cfg.GetDialTimeout(),
+			ReadTimeout:  cfg.GetReadTimeout(),
+			WriteTimeout: cfg.GetWriteTimeout(),
+			PoolTimeout:  cfg.GetPoolTimeout(),
+		}), nil
+	case RedisModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel: masterName and addrs are required")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelUsername: cfg.SentinelUsername,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.GetMinIdleConns(),
+			MaxRetries:       cfg.GetMaxRetries(),
+			DialTimeout:      cfg.GetDialTimeout(),
+			ReadTimeout:      cfg.GetReadTimeout(),
+			WriteTimeout:     cfg.GetWriteTimeout(),
+			PoolTimeout:      cfg.GetPoolTimeout(),
+		}), nil
+	case RedisModeCluster:
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("redis cluster: addrs are required")
+		}
+		if cfg.DB != 0 {
+			return nil, fmt.Errorf("redis cluster: db %d is not supported", cfg.DB)
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.GetMinIdleConns(),
+			MaxRetries:   cfg.GetMaxRetries(),
+			DialTimeout:  cfg.GetDialTimeout(),
+			ReadTimeout:  cfg.GetReadTimeout(),
+			WriteTimeout: cfg.GetWriteTimeout(),
+			PoolTimeout:  cfg.GetPoolTimeout(),
+		}), nil
+	default:
+		return nil, fmt.Errorf("redis: unknown mode %s", cfg.Mode)
+	}
+}
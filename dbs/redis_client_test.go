@@ -0,0 +1,93 @@
+package dbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/ville-vv/gutils/dbs/redistest"
+)
+
+func TestNewUniversalClient(t *testing.T) {
+	rds, err := newUniversalClient(&RedisConfig{Addr: "127.0.0.1:6379"})
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, rds)
+	_ = rds.Close()
+
+	rds, err = newUniversalClient(&RedisConfig{Mode: RedisModeCluster, Addrs: []string{"127.0.0.1:7000", "127.0.0.1:7001"}})
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.ClusterClient{}, rds)
+	_ = rds.Close()
+
+	rds, err = newUniversalClient(&RedisConfig{Mode: RedisModeSentinel, MasterName: "mymaster", Addrs: []string{"127.0.0.1:26379"}})
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, rds)
+	_ = rds.Close()
+
+	_, err = newUniversalClient(&RedisConfig{Mode: RedisModeSentinel, Addrs: []string{"127.0.0.1:26379"}})
+	assert.Error(t, err)
+	_, err = newUniversalClient(&RedisConfig{Mode: RedisModeCluster, Addrs: []string{"127.0.0.1:7000"}, DB: 1})
+	assert.Error(t, err)
+	_, err = newUniversalClient(&RedisConfig{Mode: "x", Addr: "127.0.0.1:6379"})
+	assert.Error(t, err)
+}
+
+func TestRedisConfig_TLSConfig(t *testing.T) {
+	conf, err := (&RedisConfig{}).TLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, conf)
+
+	conf, err = (&RedisConfig{Tls: true, TlsServerName: "redis.local"}).TLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "redis.local", conf.ServerName)
+	assert.False(t, conf.InsecureSkipVerify)
+
+	_, err = (&RedisConfig{Tls: true, TlsCAFile: "testdata/not-exist.pem"}).TLSConfig()
+	assert.Error(t, err)
+}
+
+func TestNewRedisClient_Error(t *testing.T) {
+	_, err := NewRedisClient(context.Background(), RedisConfig{Addr: "127.0.0.1:1", MaxRetries: -1})
+	assert.Error(t, err)
+}
+
+func TestRedisConfig_Timeouts(t *testing.T) {
+	cfg := &RedisConfig{}
+	assert.Equal(t, 5*time.Second, cfg.GetDialTimeout())
+	assert.Equal(t, 3*time.Second, cfg.GetReadTimeout())
+	assert.Equal(t, 3*time.Second, cfg.GetWriteTimeout())
+	assert.Equal(t, 4*time.Second, cfg.GetPoolTimeout())
+
+	cfg = &RedisConfig{DialTimeout: 200, ReadTimeout: 500, PoolTimeout: 2000}
+	assert.Equal(t, 200*time.Millisecond, cfg.GetDialTimeout())
+	assert.Equal(t, 500*time.Millisecond, cfg.GetReadTimeout())
+	assert.Equal(t, 500*time.Millisecond, cfg.GetWriteTimeout())
+	assert.Equal(t, 2*time.Second, cfg.GetPoolTimeout())
+
+	cfg = &RedisConfig{ReadTimeout: -1, WriteTimeout: 100}
+	assert.Equal(t, time.Duration(-1), cfg.GetReadTimeout())
+	assert.Equal(t, 100*time.Millisecond, cfg.GetWriteTimeout())
+	assert.Equal(t, 30*time.Second, cfg.GetPoolTimeout())
+
+	rds, err := newUniversalClient(&RedisConfig{Addr: "127.0.0.1:6379", ReadTimeout: 500, WriteTimeout: 800})
+	assert.NoError(t, err)
+	opt := rds.(*redis.Client).Options()
+	assert.Equal(t, 500*time.Millisecond, opt.ReadTimeout)
+	assert.Equal(t, 800*time.Millisecond, opt.WriteTimeout)
+	assert.Equal(t, 1500*time.Millisecond, opt.PoolTimeout)
+	_ = rds.Close()
+}
+
+func TestMustRedisClient(t *testing.T) {
+	srv := redistest.Run(t, redistest.WithPassword("secret"))
+	client := MustRedisClient(RedisConfig{Addr: srv.Addr(), Password: "secret", DialTimeout: 1000})
+	defer client.Close()
+	assert.NoError(t, client.Set(context.Background(), "k", "v", 0).Err())
+
+	assert.Panics(t, func() { MustRedisClient(RedisConfig{Addr: srv.Addr(), MaxRetries: -1}) })
+	assert.Panics(t, func() {
+		MustRedisClient(RedisConfig{Mode: RedisModeCluster, Addrs: []string{srv.Addr()}, Password: "secret"})
+	})
+}
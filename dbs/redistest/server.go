@@ -0,0 +1,51 @@
+// Package redistest 为单元测试启动进程内的 redis 替身（基于 miniredis），监听 127.0.0.1 的随机端口。
+//
+// 支持 string、hash、list、set、sorted set、stream、过期、MULTI/EXEC、EVAL 和 pub/sub，
+// 测试结束时自动关闭，不依赖真实 redis。
+//
+//	srv := redistest.Run(t)
+//	rds := dbs.MustRedisClient(dbs.RedisConfig{Addr: srv.Addr()})
+package redistest
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type Option func(s *Server)
+
+// WithPassword 要求客户端使用 AUTH 认证
+func WithPassword(password string) Option {
+	return func(s *Server) {
+		s.RequireAuth(password)
+	}
+}
+
+// Server 内嵌 miniredis，FastForward、FlushAll、SetTime 等方法可以直接使用
+type Server struct {
+	*miniredis.Miniredis
+	t testing.TB
+}
+
+// Run 启动服务并在测试结束时关闭
+func Run(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	s := &Server{Miniredis: miniredis.NewMiniRedis(), t: t}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("redistest: start server: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client 返回连接到该服务的客户端，测试结束时关闭
+func (s *Server) Client() *redis.Client {
+	rds := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	s.t.Cleanup(func() { _ = rds.Close() })
+	return rds
+}
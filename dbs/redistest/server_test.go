@@ -0,0 +1,53 @@
+package redistest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	srv := Run(t)
+	rds := srv.Client()
+	ctx := context.Background()
+
+	require.NoError(t, rds.Set(ctx, "k", "v", time.Minute).Err())
+	val, err := rds.Get(ctx, "k").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "v", val)
+
+	srv.FastForward(2 * time.Minute)
+	_, err = rds.Get(ctx, "k").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	res, err := redis.NewScript(`return redis.call("INCRBY", KEYS[1], ARGV[1])`).Run(ctx, rds, []string{"n"}, 3).Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), res)
+
+	sub := rds.Subscribe(ctx, "news")
+	defer sub.Close()
+	_, err = sub.Receive(ctx)
+	require.NoError(t, err)
+	require.NoError(t, rds.Publish(ctx, "news", "hello").Err())
+	select {
+	case msg := <-sub.Channel():
+		assert.Equal(t, "hello", msg.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	srv := Run(t, WithPassword("secret"))
+	ctx := context.Background()
+
+	assert.Error(t, srv.Client().Set(ctx, "k", "v", 0).Err())
+
+	good := redis.NewClient(&redis.Options{Addr: srv.Addr(), Password: "secret"})
+	defer good.Close()
+	assert.NoError(t, good.Set(ctx, "k", "v", 0).Err())
+}
@@ -0,0 +1,267 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ShardingModulo = "modulo"
+	ShardingRange  = "range"
+	ShardingHash   = "hash" // 一致性哈希
+)
+
+var (
+	ErrShardNotFound = errors.New("shard not found for key")
+)
+
+// ShardingConfig 分库分表配置。总分片数 = len(Databases) * TablesPerDB，
+// 第 i 个分片位于 Databases[i/TablesPerDB]，表名为 fmt.Sprintf(TableFormat, Table, i)
+type ShardingConfig struct {
+	Table        string   `json:"table" yaml:"table"`                        // 逻辑表名，例如 order
+	Databases    []string `json:"databases" yaml:"databases"`                // OtherDns 中的库名，按顺序分配分片
+	TablesPerDB  int      `json:"tablesPerDb,optional" yaml:"tablesPerDb"`   // 每个库的分表数量，默认 1
+	Strategy     string   `json:"strategy,optional" yaml:"strategy"`         // modulo、range 或 hash，默认 modulo
+	Ranges       []int64  `json:"ranges,optional" yaml:"ranges"`             // range 策略下每个分片的上界（不包含），需要递增
+	VirtualNodes int      `json:"virtualNodes,optional" yaml:"virtualNodes"` // hash 策略下每个分片的虚拟节点数，默认 160
+	TableFormat  string   `json:"tableFormat,optional" yaml:"tableFormat"`   // 默认 %s_%02d
+}
+
+func (sel *ShardingConfig) GetTablesPerDB() int {
+	return getIntWithDefault(sel.TablesPerDB, 1)
+}
+
+func (sel *ShardingConfig) GetStrategy() string {
+	if sel.Strategy == "" {
+		sel.Strategy = ShardingModulo
+	}
+	return sel.Strategy
+}
+
+func (sel *ShardingConfig) GetVirtualNodes() int {
+	return getIntWithDefault(sel.VirtualNodes, 160)
+}
+
+func (sel *ShardingConfig) GetTableFormat() string {
+	if sel.TableFormat == "" {
+		sel.TableFormat = "%s_%02d"
+	}
+	return sel.TableFormat
+}
+
+// Shard 分片位置
+type Shard struct {
+	Index    int
+	Database string
+	Table    string
+}
+
+type ringNode struct {
+	hash  uint32
+	shard int
+}
+
+// Sharding 分片路由
+type Sharding struct {
+	cfg    ShardingConfig
+	db     IMySqlDB
+	shards []Shard
+	ring   []ringNode
+}
+
+// NewSharding cfg.Databases 中的库必须都配置在 OtherDns 中，否则返回错误。
+// 没有配置 OtherDns 时 OtherDB 会退回主库，同样视为没有配置；
+// db 为 nil 时只能用于 Locate 和 Shards 计算分片位置
+func NewSharding(db IMySqlDB, cfg ShardingConfig) (*Sharding, error) {
+	if len(cfg.Databases) == 0 {
+		return nil, fmt.Errorf("dbs: sharding %s has no databases", cfg.Table)
+	}
+	if db != nil {
+		for _, name := range cfg.Databases {
+			if other := db.OtherDB(name); other == nil || other == db.MainDB() {
+				return nil, fmt.Errorf("dbs: sharding %s database %s is not configured in otherDns", cfg.Table, name)
+			}
+		}
+	}
+	s := &Sharding{cfg: cfg, db: db}
+	total := len(cfg.Databases) * cfg.GetTablesPerDB()
+	for i := 0; i < total; i++ {
+		s.shards = append(s.shards, Shard{
+			Index:    i,
+			Database: cfg.Databases[i/cfg.GetTablesPerDB()],
+			Table:    fmt.Sprintf(cfg.GetTableFormat(), cfg.Table, i),
+		})
+	}
+	switch cfg.GetStrategy() {
+	case ShardingModulo:
+	case ShardingRange:
+		if len(cfg.Ranges) != total {
+			return nil, fmt.Errorf("dbs: sharding %s needs %d ranges, got %d", cfg.Table, total, len(cfg.Ranges))
+		}
+		if !sort.SliceIsSorted(cfg.Ranges, func(i, j int) bool { return cfg.Ranges[i] < cfg.Ranges[j] }) {
+			return nil, fmt.Errorf("dbs: sharding %s ranges must be ascending", cfg.Table)
+		}
+	case ShardingHash:
+		for i := range s.shards {
+			for v := 0; v < cfg.GetVirtualNodes(); v++ {
+				h := crc32.ChecksumIEEE([]byte(s.shards[i].Table + "#" + strconv.Itoa(v)))
+				s.ring = append(s.ring, ringNode{hash: h, shard: i})
+			}
+		}
+		sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	default:
+		return nil, fmt.Errorf("dbs: unknown sharding strategy %s", cfg.Strategy)
+	}
+	return s, nil
+}
+
+// shardKey 整数类型按 64 位补码使用，无符号整数与相同位模式的 int64 落在同一个分片；字符串取 fnv64 哈希。
+// ordered 表示返回值可以按 int64 比较大小，用于范围分片
+func shardKey(key interface{}) (n int64, ordered bool, err error) {
+	switch k := key.(type) {
+	case int:
+		return int64(k), true, nil
+	case int8:
+		return int64(k), true, nil
+	case int16:
+		return int64(k), true, nil
+	case int32:
+		return int64(k), true, nil
+	case int64:
+		return k, true, nil
+	case uint:
+		return unsignedShardKey(uint64(k))
+	case uint8:
+		return int64(k), true, nil
+	case uint16:
+		return int64(k), true, nil
+	case uint32:
+		return int64(k), true, nil
+	case uint64:
+		return unsignedShardKey(k)
+	case string:
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(k))
+		return int64(h.Sum64() & (1<<63 - 1)), false, nil
+	default:
+		return 0, false, fmt.Errorf("dbs: unsupported shard key type %T", key)
+	}
+}
+
+// unsignedShardKey 超过 int64 范围的值无法按范围分片
+func unsignedShardKey(k uint64) (int64, bool, error) {
+	return int64(k), k <= math.MaxInt64, nil
+}
+
+// Locate 计算分片位置
+func (s *Sharding) Locate(key interface{}) (Shard, error) {
+	n, ordered, err := shardKey(key)
+	if err != nil {
+		return Shard{}, err
+	}
+	switch s.cfg.GetStrategy() {
+	case ShardingRange:
+		if !ordered {
+			return Shard{}, fmt.Errorf("dbs: range sharding requires an integer key within int64, got %T(%v)", key, key)
+		}
+		idx := sort.Search(len(s.cfg.Ranges), func(i int) bool { return n < s.cfg.Ranges[i] })
+		if idx >= len(s.shards) {
+			return Shard{}, fmt.Errorf("%w: %v", ErrShardNotFound, key)
+		}
+		return s.shards[idx], nil
+	case ShardingHash:
+		h := crc32.ChecksumIEEE([]byte(strconv.FormatInt(n, 10)))
+		idx := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+		if idx == len(s.ring) {
+			idx = 0
+		}
+		return s.shards[s.ring[idx].shard], nil
+	default:
+		// 按无符号取模，负数（包括 math.MinInt64）也能落到合法的分片
+		return s.shards[uint64(n)%uint64(len(s.shards))], nil
+	}
+}
+
+// Shards 所有分片
+func (s *Sharding) Shards() []Shard {
+	return s.shards
+}
+
+func (s *Sharding) shardDB(ctx context.Context, shard Shard) *gorm.DB {
+	return s.db.OtherDB(shard.Database).WithContext(ctx).Table(shard.Table)
+}
+
+// DB 返回 key 所在分片的库，并且已经指定了分表名
+func (s *Sharding) DB(ctx context.Context, key interface{}) (*gorm.DB, error) {
+	shard, err := s.Locate(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.shardDB(ctx, shard), nil
+}
+
+// Scope 把查询的表名改写为 key 所在的分表，调用方需要保证 db 是分片所在的库
+//
+//	db.Scopes(sharding.Scope(orderID)).Where("id = ?", orderID).First(&order)
+func (s *Sharding) Scope(key interface{}) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		shard, err := s.Locate(key)
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		return db.Table(shard.Table)
+	}
+}
+
+// Each 并发地在所有分片上执行 fn，返回第一个错误
+func (s *Sharding) Each(ctx context.Context, fn func(ctx context.Context, db *gorm.DB, shard Shard) error) error {
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, shard := range s.shards {
+		wg.Add(1)
+		go func(shard Shard) {
+			defer wg.Done()
+			if err := fn(ctx, s.shardDB(ctx, shard), shard); err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("shard %s.%s: %w", shard.Database, shard.Table, err)
+					cancel()
+				})
+			}
+		}(shard)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// ScatterGather 在所有分片上执行查询并合并结果，结果按分片顺序拼接，排序和截断由调用方处理
+func ScatterGather[T any](ctx context.Context, s *Sharding, fn func(ctx context.Context, db *gorm.DB, shard Shard) ([]T, error)) ([]T, error) {
+	parts := make([][]T, len(s.shards))
+	err := s.Each(ctx, func(ctx context.Context, db *gorm.DB, shard Shard) error {
+		list, err := fn(ctx, db, shard)
+		parts[shard.Index] = list
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []T
+	for _, p := range parts {
+		res = append(res, p...)
+	}
+	return res, nil
+}
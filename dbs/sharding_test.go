@@ -0,0 +1,114 @@
+package dbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSharding_Locate(t *testing.T) {
+	s, err := NewSharding(nil, ShardingConfig{Table: "order", Databases: []string{"db0", "db1"}, TablesPerDB: 2})
+	assert.NoError(t, err)
+	assert.Len(t, s.Shards(), 4)
+	shard, err := s.Locate(int64(7))
+	assert.NoError(t, err)
+	assert.Equal(t, Shard{Index: 3, Database: "db1", Table: "order_03"}, shard)
+	_, err = s.Locate(1.5)
+	assert.Error(t, err)
+	shard, err = s.Locate(int64(math.MinInt64))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, shard.Index)
+	shard, err = s.Locate(-1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, shard.Index)
+
+	s, err = NewSharding(nil, ShardingConfig{Table: "order", Databases: []string{"db0", "db1"}, Strategy: ShardingRange, Ranges: []int64{100, 200}})
+	assert.NoError(t, err)
+	shard, _ = s.Locate(99)
+	assert.Equal(t, "db0", shard.Database)
+	shard, _ = s.Locate(100)
+	assert.Equal(t, "order_01", shard.Table)
+	_, err = s.Locate(200)
+	assert.ErrorIs(t, err, ErrShardNotFound)
+	_, err = s.Locate("abc")
+	assert.Error(t, err)
+
+	_, err = NewSharding(nil, ShardingConfig{Table: "order", Databases: []string{"db0"}, Strategy: ShardingRange, Ranges: []int64{1, 2}})
+	assert.Error(t, err)
+}
+
+func TestSharding_LocateUnsigned(t *testing.T) {
+	big := uint64(1<<63 + 5)
+	for _, strategy := range []string{ShardingModulo, ShardingHash} {
+		s, err := NewSharding(nil, ShardingConfig{Table: "order", Databases: []string{"db0", "db1"}, TablesPerDB: 2, Strategy: strategy})
+		assert.NoError(t, err)
+		for _, k := range []uint64{7, big, math.MaxUint64} {
+			a, err := s.Locate(uint(k))
+			assert.NoError(t, err)
+			b, err := s.Locate(k)
+			assert.NoError(t, err)
+			assert.Equal(t, a, b, strategy)
+		}
+		// 无符号整数按补码与 int64 等价
+		a, _ := s.Locate(uint64(math.MaxUint64))
+		b, _ := s.Locate(int64(-1))
+		assert.Equal(t, a, b, strategy)
+	}
+	s, err := NewSharding(nil, ShardingConfig{Table: "order", Databases: []string{"db0", "db1"}, Strategy: ShardingRange, Ranges: []int64{100, math.MaxInt64}})
+	assert.NoError(t, err)
+	_, err = s.Locate(uint(big))
+	assert.Error(t, err)
+	_, err = s.Locate(big)
+	assert.Error(t, err)
+	shard, err := s.Locate(uint(150))
+	assert.NoError(t, err)
+	assert.Equal(t, "db1", shard.Database)
+}
+
+func TestSharding_ConsistentHash(t *testing.T) {
+	cfg := ShardingConfig{Table: "order", Databases: []string{"db0", "db1", "db2"}, Strategy: ShardingHash}
+	s, err := NewSharding(nil, cfg)
+	assert.NoError(t, err)
+
+	counts := make(map[int]int)
+	before := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "user-" + string(rune('a'+i%26)) + string(rune(i))
+		shard, err := s.Locate(key)
+		assert.NoError(t, err)
+		counts[shard.Index]++
+		before[key] = shard.Index
+	}
+	for i := 0; i < 3; i++ {
+		assert.Greater(t, counts[i], 600)
+	}
+
+	// 增加一个分片后，大部分 key 的位置保持不变
+	cfg.Databases = append(cfg.Databases, "db3")
+	s2, _ := NewSharding(nil, cfg)
+	moved := 0
+	for key, idx := range before {
+		shard, _ := s2.Locate(key)
+		if shard.Index != idx {
+			moved++
+		}
+	}
+	assert.Less(t, moved, 1500)
+}
+
+func TestNewSharding_UnknownDatabase(t *testing.T) {
+	// 没有配置 OtherDns 时 OtherDB 退回主库，不能把所有分片都放到主库上
+	db := &MysqlDB{mainDb: &gorm.DB{}}
+	_, err := NewSharding(db, ShardingConfig{Table: "order", Databases: []string{"db0"}})
+	assert.ErrorContains(t, err, "db0")
+
+	db.otherDbs = map[string]*gorm.DB{"db0": {}}
+	_, err = NewSharding(db, ShardingConfig{Table: "order", Databases: []string{"db0", "db1"}})
+	assert.ErrorContains(t, err, "db1")
+
+	db.otherDbs["db1"] = &gorm.DB{}
+	_, err = NewSharding(db, ShardingConfig{Table: "order", Databases: []string{"db0", "db1"}})
+	assert.NoError(t, err)
+}
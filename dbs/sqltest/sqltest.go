@@ -0,0 +1,155 @@
+// Package sqltest 为单元测试提供进程内的 database/sql 替身，记录执行过的 SQL，由 Handler 按语句返回结果，
+// 不依赖真实 mysql。事务的 BEGIN、COMMIT、ROLLBACK 也会作为语句记录下来。
+//
+//	db := sqltest.Open(t, func(query string, args []driver.NamedValue) sqltest.Result {
+//		return sqltest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(1)}}}
+//	})
+//	gdb := db.Gorm(t)
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	Begin    = "BEGIN"
+	Commit   = "COMMIT"
+	Rollback = "ROLLBACK"
+)
+
+// Result 语句的执行结果，Columns 为空时查询返回空结果集，Err 不为空时语句执行失败
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+// Handler 按 SQL 和参数返回结果，nil 表示所有语句都成功且不返回数据
+type Handler func(query string, args []driver.NamedValue) Result
+
+// DB 内嵌 *sql.DB，可以直接当作连接池使用
+type DB struct {
+	*sql.DB
+	mu      sync.Mutex
+	queries []string
+	handle  Handler
+}
+
+// Open 创建连接池并在测试结束时关闭
+func Open(t testing.TB, handle Handler) *DB {
+	t.Helper()
+	d := &DB{handle: handle}
+	d.DB = sql.OpenDB(connector{d: d})
+	t.Cleanup(func() { _ = d.DB.Close() })
+	return d
+}
+
+// Gorm 基于该连接池打开 mysql 方言的 gorm.DB
+func (d *DB) Gorm(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: d.DB, SkipInitializeWithVersion: true}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("sqltest: open gorm: %v", err)
+	}
+	return db
+}
+
+// Queries 按执行顺序返回记录的 SQL
+func (d *DB) Queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+// Reset 清空记录的 SQL
+func (d *DB) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = nil
+}
+
+func (d *DB) run(query string, args []driver.NamedValue) Result {
+	d.mu.Lock()
+	d.queries = append(d.queries, query)
+	d.mu.Unlock()
+	if d.handle == nil {
+		return Result{}
+	}
+	return d.handle(query, args)
+}
+
+type connector struct{ d *DB }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return &conn{d: c.d}, nil }
+func (c connector) Driver() driver.Driver                        { return nil }
+
+type conn struct{ d *DB }
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("sqltest: prepare not supported")
+}
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if res := c.d.run(Begin, nil); res.Err != nil {
+		return nil, res.Err
+	}
+	return tx{d: c.d}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.d.run(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.d.run(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &rows{cols: res.Columns, rows: res.Rows}, nil
+}
+
+type tx struct{ d *DB }
+
+func (t tx) Commit() error   { return t.d.run(Commit, nil).Err }
+func (t tx) Rollback() error { return t.d.run(Rollback, nil).Err }
+
+type rows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.cols }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,45 @@
+package sqltest
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDB(t *testing.T) {
+	db := Open(t, func(query string, args []driver.NamedValue) Result {
+		switch query {
+		case "SELECT `id` FROM `items` WHERE id > ?":
+			return Result{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(1)}, {int64(2)}}}
+		case "DELETE FROM `items` WHERE id = ?":
+			return Result{Err: errors.New("boom")}
+		}
+		return Result{RowsAffected: 1}
+	})
+	gdb := db.Gorm(t)
+
+	var ids []int64
+	require.NoError(t, gdb.Table("items").Where("id > ?", 0).Pluck("id", &ids).Error)
+	assert.Equal(t, []int64{1, 2}, ids)
+
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec("UPDATE `items` SET name = ?", "a")
+		assert.Equal(t, int64(1), res.RowsAffected)
+		return tx.Exec("DELETE FROM `items` WHERE id = ?", 1).Error
+	})
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, []string{
+		"SELECT `id` FROM `items` WHERE id > ?",
+		Begin,
+		"UPDATE `items` SET name = ?",
+		"DELETE FROM `items` WHERE id = ?",
+		Rollback,
+	}, db.Queries())
+
+	db.Reset()
+	assert.Empty(t, db.Queries())
+}
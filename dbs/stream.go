@@ -0,0 +1,424 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ville-vv/gutils/uuids"
+	"github.com/ville-vv/gutils/vtask"
+	"github.com/ville-vv/gutils/zlog"
+)
+
+const streamDataField = "data"
+
+// StreamMessage 消费到的消息，Deliveries 为包括本次在内的投递次数
+type StreamMessage struct {
+	ID         string
+	Stream     string
+	Values     map[string]interface{}
+	Deliveries int64
+}
+
+type StreamHandler func(ctx context.Context, msg *StreamMessage) error
+
+type StreamConsumerConfig struct {
+	Stream        string        `json:"stream" yaml:"stream"`
+	Group         string        `json:"group" yaml:"group"`
+	Consumer      string        `json:"consumer,optional" yaml:"consumer"`           // 默认 hostname-随机数
+	StartID       string        `json:"startId,optional" yaml:"startId"`             // 创建消费组时的起始 id，默认 $ 只消费新消息
+	Batch         int64         `json:"batch,optional" yaml:"batch"`                 // 每次读取的条数，默认 10
+	Block         int    `json:"block,optional" yaml:"block"`                 // XREADGROUP 阻塞时间（毫秒），默认 5000，也是 Stop 的最长等待时间
+	MinIdle       int    `json:"minIdle,optional" yaml:"minIdle"`             // 未确认超过该时间（毫秒）的消息会被重新认领，默认 60000
+	ClaimInterval int    `json:"claimInterval,optional" yaml:"claimInterval"` // 认领的间隔（毫秒），默认 30000
+	MaxDeliveries int64  `json:"maxDeliveries,optional" yaml:"maxDeliveries"` // 超过投递次数进入死信 stream，默认 5
+	DeadLetter    string `json:"deadLetter,optional" yaml:"deadLetter"`       // 死信 stream，默认 {Stream}:dead
+	MaxLen        int64  `json:"maxLen,optional" yaml:"maxLen"`               // 大于 0 时认领的同时近似裁剪 stream
+
+	Pool *vtask.DynamicWorkPool `json:"-" yaml:"-"` // 处理消息的协程池，默认创建最多 Batch 个协程的池
+}
+
+func (sel *StreamConsumerConfig) GetConsumer() string {
+	if sel.Consumer == "" {
+		host, _ := os.Hostname()
+		sel.Consumer = host + "-" + uuids.NanoID()
+	}
+	return sel.Consumer
+}
+
+func (sel *StreamConsumerConfig) GetStartID() string {
+	if sel.StartID == "" {
+		sel.StartID = "$"
+	}
+	return sel.StartID
+}
+
+func (sel *StreamConsumerConfig) GetBatch() int64 {
+	if sel.Batch <= 0 {
+		sel.Batch = 10
+	}
+	return sel.Batch
+}
+
+func (sel *StreamConsumerConfig) GetBlock() time.Duration {
+	if sel.Block <= 0 {
+		sel.Block = 5000
+	}
+	return time.Duration(sel.Block) * time.Millisecond
+}
+
+func (sel *StreamConsumerConfig) GetMinIdle() time.Duration {
+	if sel.MinIdle <= 0 {
+		sel.MinIdle = 60000
+	}
+	return time.Duration(sel.MinIdle) * time.Millisecond
+}
+
+func (sel *StreamConsumerConfig) GetClaimInterval() time.Duration {
+	if sel.ClaimInterval <= 0 {
+		sel.ClaimInterval = 30000
+	}
+	return time.Duration(sel.ClaimInterval) * time.Millisecond
+}
+
+func (sel *StreamConsumerConfig) GetMaxDeliveries() int64 {
+	if sel.MaxDeliveries <= 0 {
+		sel.MaxDeliveries = 5
+	}
+	return sel.MaxDeliveries
+}
+
+func (sel *StreamConsumerConfig) GetDeadLetter() string {
+	if sel.DeadLetter == "" {
+		sel.DeadLetter = sel.Stream + ":dead"
+	}
+	return sel.DeadLetter
+}
+
+// StreamConsumer redis stream 消费组。handler 返回 nil 时 XACK，返回错误时消息留在 pending 中，
+// 超过 MinIdle 后被 XAUTOCLAIM 重新认领，投递次数超过 MaxDeliveries 后转入死信 stream
+type StreamConsumer struct {
+	rds     redis.Cmdable
+	cfg     StreamConsumerConfig
+	handler StreamHandler
+	pool    *vtask.DynamicWorkPool
+	ownPool bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func NewStreamConsumer(rds *RedisDB, cfg StreamConsumerConfig, handler StreamHandler) (*StreamConsumer, error) {
+	if cfg.Stream == "" || cfg.Group == "" {
+		return nil, fmt.Errorf("dbs: stream consumer requires stream and group")
+	}
+	// 默认值在这里一次性填好，读写两个协程只读取配置
+	cfg.GetConsumer()
+	cfg.GetStartID()
+	cfg.GetBatch()
+	cfg.GetBlock()
+	cfg.GetMinIdle()
+	cfg.GetClaimInterval()
+	cfg.GetMaxDeliveries()
+	cfg.DeadLetter = rds.Key(cfg.GetDeadLetter())
+	cfg.Stream = rds.Key(cfg.Stream)
+	c := &StreamConsumer{rds: rds.rds, cfg: cfg, handler: handler, pool: cfg.Pool}
+	if c.pool == nil {
+		c.pool = vtask.NewDynamicWorkPool(vtask.WithMinWorkers(1), vtask.WithMaxWorkers(cfg.GetBatch()))
+		c.ownPool = true
+	}
+	return c, nil
+}
+
+// CreateGroup 创建消费组，stream 不存在时一并创建，消费组已存在时忽略
+func (c *StreamConsumer) CreateGroup(ctx context.Context) error {
+	err := c.rds.XGroupCreateMkStream(ctx, c.cfg.Stream, c.cfg.Group, c.cfg.GetStartID()).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (c *StreamConsumer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.wg.Add(2)
+	go c.readLoop(ctx)
+	go c.claimLoop(ctx)
+}
+
+func (c *StreamConsumer) Stop() {
+	c.once.Do(func() {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		c.wg.Wait()
+		if c.ownPool {
+			c.pool.ReleaseWait()
+		}
+	})
+}
+
+func (c *StreamConsumer) readLoop(ctx context.Context) {
+	defer c.wg.Done()
+	groupReady := false
+	for ctx.Err() == nil {
+		if !groupReady {
+			if err := c.CreateGroup(ctx); err != nil {
+				zlog.Errorf("stream %s: create group %s: %v", c.cfg.Stream, c.cfg.Group, err)
+				c.sleep(ctx, time.Second)
+				continue
+			}
+			groupReady = true
+		}
+		streams, err := c.rds.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.cfg.Group,
+			Consumer: c.cfg.GetConsumer(),
+			Streams:  []string{c.cfg.Stream, ">"},
+			Count:    c.cfg.GetBatch(),
+			Block:    c.cfg.GetBlock(),
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				groupReady = false
+			}
+			zlog.Errorf("stream %s: read group: %v", c.cfg.Stream, err)
+			c.sleep(ctx, time.Second)
+			continue
+		}
+		for _, s := range streams {
+			c.dispatch(ctx, s.Messages, nil)
+		}
+	}
+}
+
+func (c *StreamConsumer) claimLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.cfg.GetClaimInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.Claim(ctx); err != nil && ctx.Err() == nil {
+			zlog.Errorf("stream %s: claim: %v", c.cfg.Stream, err)
+		}
+	}
+}
+
+// Claim 用 XAUTOCLAIM 按游标认领超时未确认的消息，每次最多认领 10 批。
+// 认领后的投递次数已经包含本次，超过 MaxDeliveries 的转入死信，其余重新处理
+func (c *StreamConsumer) Claim(ctx context.Context) error {
+	if c.cfg.MaxLen > 0 {
+		if err := c.rds.XTrimMaxLenApprox(ctx, c.cfg.Stream, c.cfg.MaxLen, 0).Err(); err != nil {
+			return err
+		}
+	}
+	start := "0-0"
+	for i := 0; i < 10 && ctx.Err() == nil; i++ {
+		msgs, next, err := c.rds.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.cfg.Stream,
+			Group:    c.cfg.Group,
+			Consumer: c.cfg.GetConsumer(),
+			MinIdle:  c.cfg.GetMinIdle(),
+			Start:    start,
+			Count:    c.cfg.GetBatch(),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(msgs) > 0 {
+			if err = c.redeliver(ctx, msgs); err != nil {
+				return err
+			}
+		}
+		if next == "0-0" || next == "" {
+			return nil
+		}
+		start = next
+	}
+	return nil
+}
+
+// redeliver 用 XPENDING 查询认领到的消息的投递次数，投递次数已满的转入死信，其余重新处理
+func (c *StreamConsumer) redeliver(ctx context.Context, msgs []redis.XMessage) error {
+	cmds := make([]*redis.XPendingExtCmd, len(msgs))
+	_, err := c.rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, msg := range msgs {
+			cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: c.cfg.Stream,
+				Group:  c.cfg.Group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	deliveries := make(map[string]int64, len(msgs))
+	retry := make([]redis.XMessage, 0, len(msgs))
+	for i, msg := range msgs {
+		pending := cmds[i].Val()
+		if len(pending) == 0 {
+			continue
+		}
+		// 认领后的投递次数比实际处理的次数多 1，Values 为空说明消息已被裁剪
+		if n := pending[0].RetryCount; n > c.cfg.GetMaxDeliveries() || len(msg.Values) == 0 {
+			if err = c.deadLetter(ctx, msg, n-1); err != nil {
+				return err
+			}
+			continue
+		}
+		deliveries[msg.ID] = pending[0].RetryCount - 1
+		retry = append(retry, msg)
+	}
+	c.dispatch(ctx, retry, deliveries)
+	return nil
+}
+
+// deadLetter 把消息复制到死信 stream 后确认，消息已被裁剪时只确认
+func (c *StreamConsumer) deadLetter(ctx context.Context, msg redis.XMessage, deliveries int64) error {
+	if len(msg.Values) > 0 {
+		values := make(map[string]interface{}, len(msg.Values)+3)
+		for k, v := range msg.Values {
+			values[k] = v
+		}
+		values["_source_id"] = msg.ID
+		values["_group"] = c.cfg.Group
+		values["_deliveries"] = strconv.FormatInt(deliveries, 10)
+		err := c.rds.XAdd(ctx, &redis.XAddArgs{Stream: c.cfg.GetDeadLetter(), MaxLen: c.cfg.MaxLen, Approx: c.cfg.MaxLen > 0, Values: values}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return c.rds.XAck(ctx, c.cfg.Stream, c.cfg.Group, msg.ID).Err()
+}
+
+// dispatch 把一批消息提交到协程池，等待这一批处理完再返回
+func (c *StreamConsumer) dispatch(ctx context.Context, msgs []redis.XMessage, deliveries map[string]int64) {
+	var wg sync.WaitGroup
+	for i := range msgs {
+		msg := &StreamMessage{
+			ID:         msgs[i].ID,
+			Stream:     c.cfg.Stream,
+			Values:     msgs[i].Values,
+			Deliveries: deliveries[msgs[i].ID] + 1,
+		}
+		wg.Add(1)
+		if err := c.pool.Submit(func() {
+			defer wg.Done()
+			c.handle(ctx, msg)
+		}); err != nil {
+			// 未处理的消息留在 pending 中，等待重新认领
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+}
+
+func (c *StreamConsumer) handle(ctx context.Context, msg *StreamMessage) {
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return c.handler(ctx, msg)
+	}()
+	if err != nil {
+		zlog.Warnf("stream %s: handle %s (delivery %d): %v", msg.Stream, msg.ID, msg.Deliveries, err)
+		return
+	}
+	if err = c.rds.XAck(ctx, c.cfg.Stream, c.cfg.Group, msg.ID).Err(); err != nil {
+		zlog.Errorf("stream %s: ack %s: %v", msg.Stream, msg.ID, err)
+	}
+}
+
+func (c *StreamConsumer) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// StreamProducer 带类型的生产者，消息体编码后放在 data 字段
+type StreamProducer[T any] struct {
+	rds    redis.Cmdable
+	stream string
+	maxLen int64
+	codec  Codec
+}
+
+// NewStreamProducer maxLen 大于 0 时写入时近似裁剪，codec 默认 json
+func NewStreamProducer[T any](rds *RedisDB, stream string, maxLen int64, codec ...Codec) *StreamProducer[T] {
+	p := &StreamProducer[T]{rds: rds.rds, stream: rds.Key(stream), maxLen: maxLen, codec: JSONCodec{}}
+	if len(codec) > 0 && codec[0] != nil {
+		p.codec = codec[0]
+	}
+	return p
+}
+
+// Send 写入一条消息，返回消息 id
+func (p *StreamProducer[T]) Send(ctx context.Context, v T) (string, error) {
+	data, err := p.codec.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return p.rds.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: map[string]interface{}{streamDataField: data},
+	}).Result()
+}
+
+// DecodeStream 解码 StreamProducer 写入的消息体
+func DecodeStream[T any](msg *StreamMessage, codec ...Codec) (T, error) {
+	var v T
+	c := Codec(JSONCodec{})
+	if len(codec) > 0 && codec[0] != nil {
+		c = codec[0]
+	}
+	raw, ok := msg.Values[streamDataField]
+	if !ok {
+		return v, fmt.Errorf("stream message %s has no %s field", msg.ID, streamDataField)
+	}
+	var data []byte
+	switch d := raw.(type) {
+	case string:
+		data = []byte(d)
+	case []byte:
+		data = d
+	default:
+		return v, fmt.Errorf("stream message %s: unexpected %s type %T", msg.ID, streamDataField, raw)
+	}
+	err := c.Unmarshal(data, &v)
+	return v, err
+}
+
+// TypedStreamHandler 把带类型的处理函数转换为 StreamHandler，解码失败也视为处理失败
+func TypedStreamHandler[T any](fn func(ctx context.Context, msg *StreamMessage, v T) error, codec ...Codec) StreamHandler {
+	return func(ctx context.Context, msg *StreamMessage) error {
+		v, err := DecodeStream[T](msg, codec...)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, msg, v)
+	}
+}
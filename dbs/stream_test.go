@@ -0,0 +1,175 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamOrder struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestTypedStreamHandler(t *testing.T) {
+	var got streamOrder
+	h := TypedStreamHandler(func(ctx context.Context, msg *StreamMessage, v streamOrder) error {
+		got = v
+		return nil
+	})
+	err := h(context.Background(), &StreamMessage{ID: "1-0", Values: map[string]interface{}{"data": `{"id":1,"name":"a"}`}})
+	assert.NoError(t, err)
+	assert.Equal(t, streamOrder{ID: 1, Name: "a"}, got)
+
+	err = h(context.Background(), &StreamMessage{ID: "2-0", Values: map[string]interface{}{"other": "x"}})
+	assert.Error(t, err)
+}
+
+func TestStreamConsumerConfig_Defaults(t *testing.T) {
+	cfg := StreamConsumerConfig{Stream: "orders", Group: "g"}
+	assert.Equal(t, "orders:dead", cfg.GetDeadLetter())
+	assert.Equal(t, int64(5), cfg.GetMaxDeliveries())
+	assert.NotEmpty(t, cfg.GetConsumer())
+	assert.Equal(t, cfg.GetConsumer(), cfg.Consumer)
+	assert.Equal(t, 5*time.Second, cfg.GetBlock())
+	assert.Equal(t, time.Minute, cfg.GetMinIdle())
+	assert.Equal(t, 30*time.Second, cfg.GetClaimInterval())
+	cfg.MinIdle = 1500
+	assert.Equal(t, 1500*time.Millisecond, cfg.GetMinIdle())
+
+	_, err := NewStreamConsumer(&RedisDB{}, StreamConsumerConfig{Stream: "orders"}, nil)
+	assert.Error(t, err)
+}
+
+// streamRecorder 记录 handler 收到的消息
+type streamRecorder struct {
+	mu   sync.Mutex
+	msgs []StreamMessage
+	fail bool
+}
+
+func (r *streamRecorder) handle(ctx context.Context, msg *StreamMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, *msg)
+	if r.fail {
+		return errors.New("fail")
+	}
+	return nil
+}
+
+func (r *streamRecorder) deliveries() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]int64, 0, len(r.msgs))
+	for _, m := range r.msgs {
+		res = append(res, m.Deliveries)
+	}
+	return res
+}
+
+func TestStreamConsumer_Dispatch(t *testing.T) {
+	_, rds := newTestRedisDB(t, WithKeyPrefix("app:"))
+	ctx := context.Background()
+	p := NewStreamProducer[streamOrder](rds, "orders", 0)
+	for i := 1; i <= 3; i++ {
+		_, err := p.Send(ctx, streamOrder{ID: int64(i)})
+		require.NoError(t, err)
+	}
+
+	rec := &streamRecorder{}
+	c, err := NewStreamConsumer(rds, StreamConsumerConfig{Stream: "orders", Group: "g", StartID: "0", Block: 50}, rec.handle)
+	require.NoError(t, err)
+	c.Start()
+	defer c.Stop()
+
+	require.Eventually(t, func() bool { return len(rec.deliveries()) == 3 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 1, 1}, rec.deliveries())
+	// 处理成功后 XACK，pending 为空
+	require.Eventually(t, func() bool {
+		pending, err := rds.rds.XPending(ctx, "app:orders", "g").Result()
+		return err == nil && pending.Count == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestStreamConsumer_DeadLetter(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	id, err := NewStreamProducer[streamOrder](rds, "orders", 0).Send(ctx, streamOrder{ID: 1})
+	require.NoError(t, err)
+
+	rec := &streamRecorder{fail: true}
+	c, err := NewStreamConsumer(rds, StreamConsumerConfig{Stream: "orders", Group: "g", StartID: "0", Block: 50,
+		MinIdle: 50, ClaimInterval: 20, MaxDeliveries: 3}, rec.handle)
+	require.NoError(t, err)
+	c.Start()
+	defer c.Stop()
+
+	// 第一次投递失败后被重新认领两次，第三次仍然失败后转入死信
+	require.Eventually(t, func() bool {
+		n, err := rds.rds.XLen(ctx, "orders:dead").Result()
+		return err == nil && n == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 2, 3}, rec.deliveries())
+
+	dead, err := rds.rds.XRange(ctx, "orders:dead", "-", "+").Result()
+	require.NoError(t, err)
+	assert.Equal(t, id, dead[0].Values["_source_id"])
+	assert.Equal(t, "3", dead[0].Values["_deliveries"])
+	assert.Equal(t, "g", dead[0].Values["_group"])
+	pending, err := rds.rds.XPending(ctx, "orders", "g").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
+
+func TestStreamConsumer_ClaimInspectedOnly(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	require.NoError(t, rds.rds.XGroupCreateMkStream(ctx, "orders", "g", "0").Err())
+	for i := 0; i < 12; i++ {
+		require.NoError(t, rds.rds.XAdd(ctx, &redis.XAddArgs{Stream: "orders", Values: map[string]interface{}{"i": fmt.Sprint(i)}}).Err())
+	}
+	// 另一个消费者读取后没有确认
+	_, err := rds.rds.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "crashed", Streams: []string{"orders", ">"}}).Result()
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	// Batch 为 1 时每次 Claim 最多认领 10 批，剩下的留给下一次
+	rec := &streamRecorder{}
+	c, err := NewStreamConsumer(rds, StreamConsumerConfig{Stream: "orders", Group: "g", Batch: 1, MinIdle: 50}, rec.handle)
+	require.NoError(t, err)
+	defer c.Stop()
+	require.NoError(t, c.Claim(ctx))
+	assert.Len(t, rec.deliveries(), 10)
+	for _, d := range rec.deliveries() {
+		assert.Equal(t, int64(2), d)
+	}
+	pending, err := rds.rds.XPending(ctx, "orders", "g").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pending.Count)
+	assert.Equal(t, int64(2), pending.Consumers["crashed"])
+}
+
+func TestStreamConsumer_MaxLen(t *testing.T) {
+	_, rds := newTestRedisDB(t)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rds.rds.XAdd(ctx, &redis.XAddArgs{Stream: "orders", Values: map[string]interface{}{"i": fmt.Sprint(i)}}).Err())
+	}
+	require.NoError(t, rds.rds.XGroupCreate(ctx, "orders", "g", "$").Err())
+
+	c, err := NewStreamConsumer(rds, StreamConsumerConfig{Stream: "orders", Group: "g", MaxLen: 2}, func(ctx context.Context, msg *StreamMessage) error { return nil })
+	require.NoError(t, err)
+	defer c.Stop()
+	require.NoError(t, c.Claim(ctx))
+	n, err := rds.rds.XLen(ctx, "orders").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
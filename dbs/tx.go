@@ -0,0 +1,244 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+var (
+	ErrNoDefaultDB  = errors.New("dbs: default mysql db is not initialized, call InitMysqlDB or SetDefaultMysqlDB first")
+	ErrTxDBMismatch = errors.New("dbs: ctx already has a transaction on a different db")
+)
+
+var (
+	_defaultDB   IMySqlDB
+	_defaultDBMu sync.RWMutex
+
+	_noDB     *gorm.DB
+	_noDBOnce sync.Once
+)
+
+// SetDefaultMysqlDB 设置 DB(ctx) 和 WithTx 使用的默认数据库，InitMysqlDB 会自动设置
+func SetDefaultMysqlDB(db IMySqlDB) {
+	_defaultDBMu.Lock()
+	defer _defaultDBMu.Unlock()
+	_defaultDB = db
+}
+
+func defaultMainDB() (*gorm.DB, error) {
+	_defaultDBMu.RLock()
+	defer _defaultDBMu.RUnlock()
+	if _defaultDB == nil {
+		return nil, ErrNoDefaultDB
+	}
+	return _defaultDB.MainDB(), nil
+}
+
+// errorDB 返回带有 err 的 *gorm.DB，之后的链式调用和查询都直接返回 err，不会访问数据库
+func errorDB(ctx context.Context, err error) *gorm.DB {
+	_noDBOnce.Do(func() {
+		db, openErr := gorm.Open(gormmysql.New(gormmysql.Config{SkipInitializeWithVersion: true}),
+			&gorm.Config{DisableAutomaticPing: true, DryRun: true, SkipDefaultTransaction: true})
+		if openErr != nil {
+			panic(openErr)
+		}
+		_noDB = db
+	})
+	db := _noDB.Session(&gorm.Session{NewDB: true, Context: ctx})
+	_ = db.AddError(err)
+	return db
+}
+
+type txCtxKey struct{}
+
+// txState 一层事务（最外层事务或保存点）
+type txState struct {
+	db          *gorm.DB
+	depth       int
+	mu          sync.Mutex
+	afterCommit []func(ctx context.Context)
+}
+
+func (s *txState) addAfterCommit(fn func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.afterCommit = append(s.afterCommit, fn)
+}
+
+func (s *txState) takeAfterCommit() []func(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fns := s.afterCommit
+	s.afterCommit = nil
+	return fns
+}
+
+func txFromContext(ctx context.Context) *txState {
+	s, _ := ctx.Value(txCtxKey{}).(*txState)
+	return s
+}
+
+type TxOptions struct {
+	Isolation  sql.IsolationLevel
+	ReadOnly   bool
+	MaxRetries int // 死锁或锁等待超时的重试次数，只对最外层事务生效
+}
+
+type TxOption func(*TxOptions)
+
+// WithIsolation 事务隔离级别
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *TxOptions) {
+		o.Isolation = level
+	}
+}
+
+// WithReadOnly 只读事务
+func WithReadOnly() TxOption {
+	return func(o *TxOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// WithTxRetry 死锁（1213）或锁等待超时（1205）时整体重试事务的次数
+func WithTxRetry(n int) TxOption {
+	return func(o *TxOptions) {
+		o.MaxRetries = n
+	}
+}
+
+// DB 返回 ctx 中正在进行的事务，没有事务时返回默认数据库的主库。
+// 没有设置默认数据库时返回的 *gorm.DB 带有 ErrNoDefaultDB，执行任何语句都会返回该错误
+func DB(ctx context.Context) *gorm.DB {
+	if s := txFromContext(ctx); s != nil {
+		return s.db
+	}
+	db, err := defaultMainDB()
+	if err != nil {
+		return errorDB(ctx, err)
+	}
+	return db.WithContext(ctx)
+}
+
+// WithTx 在默认数据库的主库上执行事务，事务保存在 fn 的 ctx 中，通过 DB(ctx) 获取。
+// ctx 中已经有事务时使用保存点实现嵌套事务，fn 返回错误只回滚到保存点，此时 opts 被忽略，
+// 隔离级别、只读和重试都沿用最外层事务。没有设置默认数据库时返回 ErrNoDefaultDB
+func WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if s := txFromContext(ctx); s != nil {
+		return nestedTx(ctx, s, fn)
+	}
+	db, err := defaultMainDB()
+	if err != nil {
+		return err
+	}
+	return WithTxDB(ctx, db, fn, opts...)
+}
+
+// WithTxDB 与 WithTx 相同，但在指定的数据库上开启事务。
+// ctx 中已经有同一个数据库上的事务时同样使用保存点并忽略 opts；
+// 事务属于其他数据库时返回 ErrTxDBMismatch，需要独立事务时传入不带事务的 ctx
+func WithTxDB(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if s := txFromContext(ctx); s != nil {
+		if !sameConnPool(s.db, db) {
+			return ErrTxDBMismatch
+		}
+		return nestedTx(ctx, s, fn)
+	}
+	o := &TxOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	txOpts := &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly}
+	for i := 0; ; i++ {
+		err := runTx(ctx, db, txOpts, fn)
+		if err == nil || i >= o.MaxRetries || !IsRetryableTxErr(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(i+1)*time.Millisecond*50 + time.Duration(rand.Intn(50))*time.Millisecond):
+		}
+	}
+}
+
+// sameConnPool tx 是否是在 db 的连接上开启的事务，Session 和事务会复制 Config，但 Dialector 保持不变
+func sameConnPool(tx, db *gorm.DB) bool {
+	return tx.Dialector == db.Dialector
+}
+
+func runTx(ctx context.Context, db *gorm.DB, txOpts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	var s *txState
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		s = &txState{db: tx}
+		return fn(context.WithValue(ctx, txCtxKey{}, s))
+	}, txOpts)
+	if err != nil {
+		return err
+	}
+	for _, hook := range s.takeAfterCommit() {
+		hook(ctx)
+	}
+	return nil
+}
+
+func nestedTx(ctx context.Context, parent *txState, fn func(ctx context.Context) error) (err error) {
+	s := &txState{db: parent.db, depth: parent.depth + 1}
+	name := fmt.Sprintf("dbs_sp_%d", s.depth)
+	if err = parent.db.SavePoint(name).Error; err != nil {
+		return err
+	}
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			parent.db.RollbackTo(name)
+		}
+	}()
+	err = fn(context.WithValue(ctx, txCtxKey{}, s))
+	panicked = false
+	if err != nil {
+		return err
+	}
+	// 保存点成功后，钩子交给上一层，等最外层事务提交后再执行
+	for _, hook := range s.takeAfterCommit() {
+		parent.addAfterCommit(hook)
+	}
+	return nil
+}
+
+// AfterCommit 注册最外层事务提交成功后执行的钩子，事务回滚时不会执行。ctx 中没有事务时立即执行
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if s := txFromContext(ctx); s != nil {
+		s.addAfterCommit(fn)
+		return
+	}
+	fn(ctx)
+}
+
+// InTx ctx 中是否有正在进行的事务
+func InTx(ctx context.Context) bool {
+	return txFromContext(ctx) != nil
+}
+
+// IsRetryableTxErr 是否为死锁或锁等待超时错误
+func IsRetryableTxErr(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlErrDeadlock || myErr.Number == mysqlErrLockWaitTimeout
+	}
+	return false
+}
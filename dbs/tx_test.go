@@ -0,0 +1,62 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestIsRetryableTxErr(t *testing.T) {
+	assert.True(t, IsRetryableTxErr(&mysql.MySQLError{Number: 1213}))
+	assert.True(t, IsRetryableTxErr(fmt.Errorf("update: %w", &mysql.MySQLError{Number: 1205})))
+	assert.False(t, IsRetryableTxErr(&mysql.MySQLError{Number: 1062}))
+	assert.False(t, IsRetryableTxErr(errors.New("deadlock")))
+}
+
+func TestAfterCommit_NoTx(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, InTx(ctx))
+	called := false
+	AfterCommit(ctx, func(ctx context.Context) {
+		called = true
+	})
+	assert.True(t, called)
+}
+
+func TestDB_NoDefault(t *testing.T) {
+	_defaultDBMu.RLock()
+	old := _defaultDB
+	_defaultDBMu.RUnlock()
+	SetDefaultMysqlDB(nil)
+	defer SetDefaultMysqlDB(old)
+
+	ctx := context.Background()
+	var n int64
+	assert.ErrorIs(t, DB(ctx).Table("orders").Count(&n).Error, ErrNoDefaultDB)
+	assert.ErrorIs(t, WithTx(ctx, func(ctx context.Context) error { return nil }), ErrNoDefaultDB)
+}
+
+func TestWithTxDB_Mismatch(t *testing.T) {
+	open := func() *gorm.DB {
+		db, err := gorm.Open(gormmysql.New(gormmysql.Config{DSN: "root:root@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+			&gorm.Config{DisableAutomaticPing: true, DryRun: true, SkipDefaultTransaction: true})
+		assert.NoError(t, err)
+		return db
+	}
+	dbA, dbB := open(), open()
+	ctx := context.WithValue(context.Background(), txCtxKey{}, &txState{db: dbA.Session(&gorm.Session{})})
+
+	called := false
+	assert.NoError(t, WithTxDB(ctx, dbA, func(ctx context.Context) error {
+		called = true
+		return nil
+	}))
+	assert.True(t, called)
+	assert.ErrorIs(t, WithTxDB(ctx, dbB, func(ctx context.Context) error { return nil }), ErrTxDBMismatch)
+}
@@ -0,0 +1,249 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ville-vv/gutils/runner"
+)
+
+type ElectorOption func(*Elector)
+
+// WithElectorTTL 领导者租约时长，续约间隔为 ttl/3
+func WithElectorTTL(ttl time.Duration) ElectorOption {
+	return func(e *Elector) {
+		e.ttl = ttl
+	}
+}
+
+// WithElectorRetryTick 非领导者竞选的重试间隔
+func WithElectorRetryTick(d time.Duration) ElectorOption {
+	return func(e *Elector) {
+		e.retryTick = d
+	}
+}
+
+// Elector 基于 RedisLock 的领导者选举，锁的值为节点标识，fencing token 作为任期号
+type Elector struct {
+	lock      *RedisLock
+	name      string
+	id        string
+	ttl       time.Duration
+	retryTick time.Duration
+	leading   atomic.Bool
+	term      atomic.Int64
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+}
+
+// NewElector name 为选举的名称，同名的 Elector 竞争同一个领导者；id 为当前节点的唯一标识
+func NewElector(lock *RedisLock, name string, id string, opts ...ElectorOption) *Elector {
+	e := &Elector{
+		lock:      lock,
+		name:      name,
+		id:        id,
+		ttl:       time.Second * 15,
+		retryTick: time.Second * 2,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Run 参与选举直到 ctx 结束或调用 Resign。
+// 当选时在新的协程中调用 onElected，传入的 ctx 会在失去领导权时取消；
+// 失去领导权时（续约失败或主动退出）同步调用 onRevoked。退出前会主动释放领导权。
+// 每次续约最多等待 ttl/3，从最近一次确认成功的续约发出时刻起，
+// 超过 ttl 减去安全余量仍未续约成功就视为失去领导权，保证在锁过期之前撤销
+func (e *Elector) Run(ctx context.Context, onElected func(ctx context.Context, term int64), onRevoked func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	e.mu.Lock()
+	e.cancel = cancel
+	e.mu.Unlock()
+	defer cancel()
+
+	var (
+		leaderCancel context.CancelFunc
+		// 租约确认有效的截止时间
+		deadline time.Time
+	)
+	elected := func(term int64) {
+		e.term.Store(term)
+		e.leading.Store(true)
+		var leaderCtx context.Context
+		leaderCtx, leaderCancel = context.WithCancel(ctx)
+		if onElected != nil {
+			go onElected(leaderCtx, term)
+		}
+	}
+	revoke := func() {
+		e.leading.Store(false)
+		leaderCancel()
+		if onRevoked != nil {
+			onRevoked()
+		}
+	}
+	for {
+		tick := e.retryTick
+		if e.leading.Load() {
+			tick = e.ttl / 3
+			start := time.Now()
+			if start.Before(deadline) {
+				err := e.renew(ctx, start, deadline)
+				switch {
+				case err == nil:
+					deadline = e.leaseDeadline(start)
+				case errors.Is(err, ErrLockLost):
+					revoke()
+				}
+			}
+			// 锁已被他人持有，或者一直没能续约成功直到租约即将过期，都视为失去领导权
+			if e.leading.Load() && !time.Now().Before(deadline) {
+				revoke()
+			}
+		} else {
+			start := time.Now()
+			attemptCtx, attemptCancel := context.WithTimeout(ctx, e.ttl/3)
+			term, ok, err := e.lock.tryLockWithFence(attemptCtx, e.name, e.id, e.ttl)
+			attemptCancel()
+			if err == nil && ok {
+				deadline = e.leaseDeadline(start)
+				elected(term)
+				tick = e.ttl / 3
+			}
+		}
+		// 续约失败时在租约截止时间醒来撤销领导权
+		if e.leading.Load() {
+			if d := time.Until(deadline); d < tick {
+				tick = d
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if e.leading.Load() {
+				_ = e.lock.UnLock(e.name, e.id)
+				revoke()
+			}
+			return ctx.Err()
+		case <-time.After(tick):
+		}
+	}
+}
+
+// leaseDeadline 请求在 start 发出时租约至少有效到 start+ttl，预留 ttl/10 应对时钟误差
+func (e *Elector) leaseDeadline(start time.Time) time.Time {
+	return start.Add(e.ttl - e.ttl/10)
+}
+
+// renew 续约最多等待 ttl/3，且不超过租约截止时间
+func (e *Elector) renew(ctx context.Context, start time.Time, deadline time.Time) error {
+	if d := start.Add(e.ttl / 3); d.Before(deadline) {
+		deadline = d
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	return e.lock.renew(ctx, e.name, e.id, e.ttl)
+}
+
+// Resign 主动放弃领导权并退出 Run，用于服务关闭
+func (e *Elector) Resign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
+
+// IsLeader 当前节点是否为领导者
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Term 当前节点最近一次当选的任期号
+func (e *Elector) Term() int64 {
+	return e.term.Load()
+}
+
+// ID 当前节点标识
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// Leader 查询当前领导者的标识和最新任期号，没有领导者时 id 为空
+func (e *Elector) Leader(ctx context.Context) (string, int64, error) {
+	id, err := e.lock.rds.Get(ctx, e.lock.lockKey(e.name)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, err
+	}
+	term, err := e.lock.rds.Get(ctx, e.lock.fenceKey(e.name)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, err
+	}
+	return id, term, nil
+}
+
+// LeaderServer 只在当选时运行的 runner.Server，可以直接加入 runner.ServerGroup。
+// 当选时调用 svr.Start，失去领导权时调用 svr.Stop，因此 svr 需要支持停止后再次启动。
+// Start 和 Stop 串行调用，svr.Start 不能阻塞
+type LeaderServer struct {
+	elector *Elector
+	svr     runner.Server
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started atomic.Bool
+	done    chan struct{}
+	mu      sync.Mutex
+	running bool
+}
+
+func NewLeaderServer(elector *Elector, svr runner.Server) *LeaderServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LeaderServer{
+		elector: elector,
+		svr:     svr,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+func (sel *LeaderServer) Start() {
+	if !sel.started.CompareAndSwap(false, true) {
+		return
+	}
+	defer close(sel.done)
+	_ = sel.elector.Run(sel.ctx, sel.onElected, sel.onRevoked)
+}
+
+func (sel *LeaderServer) Stop() {
+	sel.cancel()
+	if sel.started.Load() {
+		<-sel.done
+	}
+}
+
+// onElected 在单独的协程中执行，可能晚于 onRevoked，此时 ctx 已经取消，不再启动
+func (sel *LeaderServer) onElected(ctx context.Context, term int64) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	if ctx.Err() != nil || sel.running {
+		return
+	}
+	sel.svr.Start()
+	sel.running = true
+}
+
+func (sel *LeaderServer) onRevoked() {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	if sel.running {
+		sel.svr.Stop()
+		sel.running = false
+	}
+}
@@ -0,0 +1,237 @@
+package locks
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs/redistest"
+)
+
+// runElectors 启动 n 个同名 Elector，测试结束时全部退出
+func runElectors(t *testing.T, lock *RedisLock, n int) []*Elector {
+	var wg sync.WaitGroup
+	electors := make([]*Elector, n)
+	for i := range electors {
+		e := NewElector(lock, "job", string(rune('a'+i)), WithElectorTTL(300*time.Millisecond), WithElectorRetryTick(20*time.Millisecond))
+		electors[i] = e
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = e.Run(context.Background(), nil, nil)
+		}()
+	}
+	t.Cleanup(func() {
+		for _, e := range electors {
+			e.Resign()
+		}
+		wg.Wait()
+	})
+	return electors
+}
+
+func leaders(electors []*Elector) []*Elector {
+	var res []*Elector
+	for _, e := range electors {
+		if e.IsLeader() {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func waitLeader(t *testing.T, electors []*Elector) *Elector {
+	var leader *Elector
+	require.Eventually(t, func() bool {
+		ls := leaders(electors)
+		if len(ls) == 1 {
+			leader = ls[0]
+		}
+		return leader != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func TestElector_SingleLeader(t *testing.T) {
+	srv := redistest.Run(t)
+	lock := NewRedisLock(srv.Client())
+	electors := runElectors(t, lock, 5)
+
+	leader := waitLeader(t, electors)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []*Elector{leader}, leaders(electors))
+		time.Sleep(30 * time.Millisecond)
+	}
+	id, term, err := leader.Leader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, leader.ID(), id)
+	assert.Equal(t, leader.Term(), term)
+}
+
+func TestElector_FailoverOnResign(t *testing.T) {
+	srv := redistest.Run(t)
+	lock := NewRedisLock(srv.Client())
+	electors := runElectors(t, lock, 3)
+
+	leader := waitLeader(t, electors)
+	term := leader.Term()
+	rest := make([]*Elector, 0, 2)
+	for _, e := range electors {
+		if e != leader {
+			rest = append(rest, e)
+		}
+	}
+	leader.Resign()
+	require.Eventually(t, func() bool { return !leader.IsLeader() }, time.Second, 10*time.Millisecond)
+
+	next := waitLeader(t, rest)
+	assert.Greater(t, next.Term(), term)
+	assert.False(t, leader.IsLeader())
+}
+
+func TestElector_FailoverOnExpire(t *testing.T) {
+	srv := redistest.Run(t)
+	lock := NewRedisLock(srv.Client())
+
+	// 模拟崩溃的领导者：持有锁但不再续约
+	term, ok, err := lock.tryLockWithFence(context.Background(), "job", "dead", 300*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	var elected, revoked atomic.Int32
+	e := NewElector(lock, "job", "a", WithElectorTTL(300*time.Millisecond), WithElectorRetryTick(20*time.Millisecond))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = e.Run(context.Background(), func(ctx context.Context, term int64) { elected.Add(1) }, func() { revoked.Add(1) })
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, e.IsLeader())
+
+	srv.FastForward(time.Second)
+	require.Eventually(t, e.IsLeader, time.Second, 10*time.Millisecond)
+	assert.Greater(t, e.Term(), term)
+	require.Eventually(t, func() bool { return elected.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// 每次接管任期号都会递增
+	last := e.Term()
+	require.Eventually(t, func() bool {
+		srv.FastForward(time.Second)
+		_, ok, err := lock.tryLockWithFence(context.Background(), "job", "other", 300*time.Millisecond)
+		return err == nil && ok
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return !e.IsLeader() }, time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, revoked.Load(), int32(1))
+
+	srv.FastForward(time.Second)
+	require.Eventually(t, e.IsLeader, time.Second, 10*time.Millisecond)
+	assert.Greater(t, e.Term(), last+1)
+
+	e.Resign()
+	<-done
+}
+
+// stallHook stalled 时阻塞所有命令直到 ctx 结束，并记录最近一次成功命令的发出时间
+type stallHook struct {
+	stalled atomic.Bool
+	lastOK  atomic.Int64
+}
+
+func (h *stallHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *stallHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		if h.stalled.Load() {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		err := next(ctx, cmd)
+		if err == nil {
+			h.lastOK.Store(start.UnixNano())
+		}
+		return err
+	}
+}
+
+func (h *stallHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestElector_RevokeBeforeExpire(t *testing.T) {
+	srv := redistest.Run(t)
+	hook := &stallHook{}
+	rds := srv.Client()
+	rds.AddHook(hook)
+	ttl := 300 * time.Millisecond
+
+	var revokedAt atomic.Int64
+	e := NewElector(NewRedisLock(rds), "job", "a", WithElectorTTL(ttl), WithElectorRetryTick(20*time.Millisecond))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = e.Run(context.Background(), nil, func() { revokedAt.Store(time.Now().UnixNano()) })
+	}()
+	require.Eventually(t, e.IsLeader, time.Second, 10*time.Millisecond)
+	time.Sleep(ttl / 2)
+
+	// redis 无响应时续约一直挂起，必须在最后一次成功续约的租约过期之前撤销
+	hook.stalled.Store(true)
+	require.Eventually(t, func() bool { return !e.IsLeader() }, ttl, 5*time.Millisecond)
+	assert.Less(t, revokedAt.Load(), hook.lastOK.Load()+ttl.Nanoseconds())
+
+	hook.stalled.Store(false)
+	e.Resign()
+	<-done
+}
+
+type recordServer struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (s *recordServer) Start() { s.record("start") }
+func (s *recordServer) Stop()  { s.record("stop") }
+
+func (s *recordServer) record(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func TestLeaderServer_StartStopOrder(t *testing.T) {
+	svr := &recordServer{}
+	ls := NewLeaderServer(nil, svr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ls.onElected(ctx, 1)
+	cancel()
+	ls.onRevoked()
+
+	// 领导权快速翻转，onRevoked 先于 onElected 执行时不能再启动
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	ls.onRevoked()
+	ls.onElected(ctx, 2)
+	assert.Equal(t, []string{"start", "stop"}, svr.events)
+
+	// 通过 Elector 当选和退出
+	srv := redistest.Run(t)
+	e := NewElector(NewRedisLock(srv.Client()), "job", "a", WithElectorTTL(300*time.Millisecond), WithElectorRetryTick(20*time.Millisecond))
+	svr = &recordServer{}
+	ls = NewLeaderServer(e, svr)
+	go ls.Start()
+	require.Eventually(t, func() bool {
+		svr.mu.Lock()
+		defer svr.mu.Unlock()
+		return len(svr.events) == 1
+	}, time.Second, 10*time.Millisecond)
+	ls.Stop()
+	assert.Equal(t, []string{"start", "stop"}, svr.events)
+}
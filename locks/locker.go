@@ -7,6 +7,7 @@ import (
 
 var (
 	ErrToManyTimes = errors.New("too many times, please try again later")
+	ErrLockLost    = errors.New("lock is no longer held")
 )
 
 type Locker interface {
@@ -14,6 +15,12 @@ type Locker interface {
 	UnLock(key string, val string) error
 }
 
+// FencedLocker 加锁时同时返回单调递增的 fencing token
+type FencedLocker interface {
+	Locker
+	LockWithFence(key string, timeout time.Duration) (string, int64, error)
+}
+
 // Interceptor 拦截器
 type Interceptor interface {
 	Intercept(key string, timeout time.Duration) error
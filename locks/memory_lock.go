@@ -0,0 +1,258 @@
+package locks
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ville-vv/gutils/vtask"
+)
+
+const (
+	memoryLockShards = 32
+)
+
+type memoryEntry struct {
+	val      string
+	expireAt int64
+	taskID   vtask.TaskID
+}
+
+func (e *memoryEntry) expired(now int64) bool {
+	return e.expireAt <= now
+}
+
+type memoryShard struct {
+	lock  sync.Mutex
+	items map[string]*memoryEntry
+}
+
+// MemoryLockOption 内存锁配置
+type MemoryLockOption func(*memoryLockConfig)
+
+type memoryLockConfig struct {
+	shards       int
+	sweepTick    time.Duration
+	retryTick    time.Duration
+	sweepSlotNum int
+}
+
+func (sel *memoryLockConfig) getShards() int {
+	if sel.shards <= 0 {
+		return memoryLockShards
+	}
+	return sel.shards
+}
+
+func (sel *memoryLockConfig) getSweepTick() time.Duration {
+	if sel.sweepTick <= 0 {
+		return time.Millisecond * 100
+	}
+	return sel.sweepTick
+}
+
+func (sel *memoryLockConfig) getRetryTick() time.Duration {
+	if sel.retryTick <= 0 {
+		return time.Millisecond * 10
+	}
+	return sel.retryTick
+}
+
+func (sel *memoryLockConfig) getSweepSlotNum() int {
+	if sel.sweepSlotNum <= 0 {
+		return 600
+	}
+	return sel.sweepSlotNum
+}
+
+// WithMemoryLockShards 分片数量
+func WithMemoryLockShards(n int) MemoryLockOption {
+	return func(c *memoryLockConfig) {
+		c.shards = n
+	}
+}
+
+// WithMemoryLockSweepTick 过期清理的时间轮间隔
+func WithMemoryLockSweepTick(d time.Duration) MemoryLockOption {
+	return func(c *memoryLockConfig) {
+		c.sweepTick = d
+	}
+}
+
+// WithMemoryLockRetryTick Lock 抢锁失败后的重试间隔
+func WithMemoryLockRetryTick(d time.Duration) MemoryLockOption {
+	return func(c *memoryLockConfig) {
+		c.retryTick = d
+	}
+}
+
+// MemoryLock 进程内的 Locker/Interceptor/Limiter 实现，过期语义与 RedisLock 保持一致，
+// 用于单机部署和单元测试。过期在访问时惰性判断，并由时间轮定期清理。
+type MemoryLock struct {
+	shards    []*memoryShard
+	tw        *vtask.TimeWheel
+	retryTick time.Duration
+	seq       atomic.Uint64
+	fence     atomic.Int64 // 所有 key 共用的 fencing token，不需要按 key 保存和清理
+	closeOnce sync.Once
+}
+
+func NewMemoryLock(opts ...MemoryLockOption) *MemoryLock {
+	cfg := &memoryLockConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	m := &MemoryLock{
+		shards:    make([]*memoryShard, cfg.getShards()),
+		retryTick: cfg.getRetryTick(),
+		tw: vtask.NewTimeWheel(
+			vtask.WithTimeWheelInterval(cfg.getSweepTick()),
+			vtask.WithTimeWheelSlotsNum(cfg.getSweepSlotNum()),
+		),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			items: make(map[string]*memoryEntry),
+		}
+	}
+	m.tw.Start()
+	return m
+}
+
+func (m *MemoryLock) lockKey(k string) string {
+	return "Locks:" + k
+}
+
+func (m *MemoryLock) interceptKey(k string) string {
+	return "Intercept:" + k
+}
+
+func (m *MemoryLock) limitKey(k string) string {
+	return "Limit:" + k
+}
+
+func (m *MemoryLock) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+func (m *MemoryLock) newVal() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.FormatUint(m.seq.Add(1), 10)
+}
+
+// setNX 与 redis SETNX + EXPIRE 语义相同，key 不存在或已过期时写入成功
+func (m *MemoryLock) setNX(key, val string, timeout time.Duration) bool {
+	_, ok := m.set(key, val, timeout, false)
+	return ok
+}
+
+// set 写入成功且 fence 为 true 时，在分片锁内递增 fencing token，保证同一个 key 后加锁的 token 更大
+func (m *MemoryLock) set(key, val string, timeout time.Duration, fence bool) (int64, bool) {
+	s := m.shard(key)
+	now := time.Now().UnixNano()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.items[key]; ok {
+		if !old.expired(now) {
+			return 0, false
+		}
+		m.tw.RemoveTask(old.taskID)
+	}
+	entry := &memoryEntry{
+		val:      val,
+		expireAt: now + int64(timeout),
+	}
+	entry.taskID = m.tw.AddTask(timeout, func(param interface{}) {
+		m.sweep(param.(string), val)
+	}, key)
+	s.items[key] = entry
+	if !fence {
+		return 0, true
+	}
+	return m.fence.Add(1), true
+}
+
+// delIfEqual 与 RedisLock.UnLock 的脚本语义相同，只有 val 匹配时才删除
+func (m *MemoryLock) delIfEqual(key, val string) bool {
+	s := m.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.items[key]
+	if !ok || entry.val != val {
+		return false
+	}
+	delete(s.items, key)
+	m.tw.RemoveTask(entry.taskID)
+	return true
+}
+
+// sweep 时间轮回调，清理已经过期的 key
+func (m *MemoryLock) sweep(key, val string) {
+	s := m.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.items[key]
+	if !ok || entry.val != val {
+		return
+	}
+	if entry.expired(time.Now().UnixNano()) {
+		delete(s.items, key)
+	}
+}
+
+func (m *MemoryLock) Lock(key string, timeout time.Duration) (string, error) {
+	val, _, err := m.LockWithFence(key, timeout)
+	return val, err
+}
+
+// LockWithFence 加锁并返回该 key 单调递增的 fencing token
+func (m *MemoryLock) LockWithFence(key string, timeout time.Duration) (string, int64, error) {
+	val := m.newVal()
+	key = m.lockKey(key)
+	for {
+		if fence, ok := m.set(key, val, timeout, true); ok {
+			return val, fence, nil
+		}
+		time.Sleep(m.retryTick)
+	}
+}
+
+func (m *MemoryLock) UnLock(key string, val string) error {
+	m.delIfEqual(m.lockKey(key), val)
+	return nil
+}
+
+func (m *MemoryLock) Intercept(key string, timeout time.Duration) error {
+	if !m.setNX(m.interceptKey(key), m.newVal(), timeout) {
+		return ErrToManyTimes
+	}
+	return nil
+}
+
+// Allow 在 timeout 时间窗口内同一个 key 只放行一次
+func (m *MemoryLock) Allow(key string, timeout time.Duration) error {
+	if !m.setNX(m.limitKey(key), m.newVal(), timeout) {
+		return ErrToManyTimes
+	}
+	return nil
+}
+
+// Len 当前保存的 key 数量（包括尚未被清理的过期 key）
+func (m *MemoryLock) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.lock.Lock()
+		n += len(s.items)
+		s.lock.Unlock()
+	}
+	return n
+}
+
+func (m *MemoryLock) Close() {
+	m.closeOnce.Do(func() {
+		m.tw.Stop()
+	})
+}
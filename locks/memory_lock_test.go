@@ -0,0 +1,83 @@
+package locks
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLock_Lock(t *testing.T) {
+	lc := NewMemoryLock()
+	defer lc.Close()
+	key := "Order0001"
+	sum := 0
+	sumCh := 0
+	for i := 0; i < 100; i++ {
+		sum += i
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			unlockFlow, err := lc.Lock(key, time.Second*1)
+			if err != nil {
+				assert.NoError(t, err)
+				return
+			}
+			sumCh += a
+			_ = lc.UnLock(key, unlockFlow)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, sum, sumCh)
+}
+
+func TestMemoryLock_Expire(t *testing.T) {
+	lc := NewMemoryLock(WithMemoryLockSweepTick(time.Millisecond * 10))
+	defer lc.Close()
+
+	val, err := lc.Lock("Order0002", time.Millisecond*50)
+	assert.NoError(t, err)
+
+	// 过期后可以被其他持有者获取，旧持有者的 UnLock 不能释放新锁
+	begin := time.Now()
+	val2, err := lc.Lock("Order0002", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(begin) >= time.Millisecond*30)
+	assert.NotEqual(t, val, val2)
+	_ = lc.UnLock("Order0002", val)
+	assert.Equal(t, 1, lc.Len())
+	_ = lc.UnLock("Order0002", val2)
+	assert.Equal(t, 0, lc.Len())
+}
+
+func TestMemoryLock_Intercept(t *testing.T) {
+	lc := NewMemoryLock(WithMemoryLockSweepTick(time.Millisecond * 10))
+	defer lc.Close()
+
+	assert.NoError(t, lc.Intercept("user1", time.Millisecond*50))
+	assert.ErrorIs(t, lc.Intercept("user1", time.Millisecond*50), ErrToManyTimes)
+	assert.NoError(t, lc.Allow("user1", time.Millisecond*50))
+	assert.ErrorIs(t, lc.Allow("user1", time.Millisecond*50), ErrToManyTimes)
+
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, 0, lc.Len())
+	assert.NoError(t, lc.Intercept("user1", time.Millisecond*50))
+}
+
+func TestMemoryLock_LockWithFence(t *testing.T) {
+	lc := NewMemoryLock()
+	defer lc.Close()
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		val, fence, err := lc.LockWithFence("Order0003", time.Second)
+		assert.NoError(t, err)
+		assert.Greater(t, fence, last)
+		last = fence
+		_ = lc.UnLock("Order0003", val)
+	}
+}
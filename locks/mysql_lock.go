@@ -0,0 +1,233 @@
+package locks
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ville-vv/gutils/dbs"
+	"github.com/ville-vv/gutils/uuids"
+	"gorm.io/gorm"
+)
+
+const (
+	// mysql GET_LOCK 的锁名最长 64 个字符
+	mysqlLockNameMaxLen = 64
+	defaultLeaseTable   = "distributed_lock"
+)
+
+func mysqlLockName(key string) string {
+	name := "Locks:" + key
+	if len(name) <= mysqlLockNameMaxLen {
+		return name
+	}
+	sum := sha1.Sum([]byte(key))
+	return "Locks:" + hex.EncodeToString(sum[:])
+}
+
+type mysqlSession struct {
+	name string
+	conn *sql.Conn
+	once sync.Once
+}
+
+func (s *mysqlSession) release() {
+	s.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		_, _ = s.conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", s.name)
+		_ = s.conn.Close()
+	})
+}
+
+// MySqlLock 基于 GET_LOCK/RELEASE_LOCK 的会话级锁，每把锁占用一个独立的连接，
+// 连接断开时 mysql 会自动释放锁。timeout 到期后在客户端主动释放
+type MySqlLock struct {
+	db       dbs.IMySqlDB
+	sessions sync.Map // map[string]*mysqlSession
+}
+
+func NewMySqlLock(db dbs.IMySqlDB) *MySqlLock {
+	return &MySqlLock{db: db}
+}
+
+func (m *MySqlLock) Lock(key string, timeout time.Duration) (string, error) {
+	sqlDB, err := m.db.MainDB().DB()
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	name := mysqlLockName(key)
+	for {
+		var res sql.NullInt64
+		// 每次最多等待 1 秒，避免单条语句长时间占用
+		if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 1)", name).Scan(&res); err != nil {
+			_ = conn.Close()
+			return "", err
+		}
+		if res.Valid && res.Int64 == 1 {
+			break
+		}
+	}
+	val := uuids.UUID()
+	sess := &mysqlSession{name: name, conn: conn}
+	m.sessions.Store(val, sess)
+	time.AfterFunc(timeout, func() {
+		if m.sessions.CompareAndDelete(val, sess) {
+			sess.release()
+		}
+	})
+	return val, nil
+}
+
+func (m *MySqlLock) UnLock(key string, val string) error {
+	v, ok := m.sessions.Load(val)
+	if !ok {
+		return nil
+	}
+	sess := v.(*mysqlSession)
+	if sess.name != mysqlLockName(key) {
+		return nil
+	}
+	if m.sessions.CompareAndDelete(val, sess) {
+		sess.release()
+	}
+	return nil
+}
+
+// LockLease 租约锁的表结构
+type LockLease struct {
+	LockKey   string    `gorm:"column:lock_key;type:varchar(191);primaryKey"`
+	Owner     string    `gorm:"column:owner;type:varchar(64);not null"`
+	Fence     int64     `gorm:"column:fence;not null;default:0"`
+	ExpiresAt time.Time `gorm:"column:expires_at;type:datetime(3);not null"`
+}
+
+// MySqlLeaseLock 基于租约表的锁，每把锁对应一行 (owner, expires_at)，不依赖连接，
+// 连接断开后锁依然有效直到租约过期。过期时间使用数据库时间判断
+type MySqlLeaseLock struct {
+	db        dbs.IMySqlDB
+	table     string
+	retryTick time.Duration
+}
+
+func NewMySqlLeaseLock(db dbs.IMySqlDB, table ...string) *MySqlLeaseLock {
+	m := &MySqlLeaseLock{
+		db:        db,
+		table:     defaultLeaseTable,
+		retryTick: time.Millisecond * 100,
+	}
+	if len(table) > 0 && table[0] != "" {
+		m.table = table[0]
+	}
+	return m
+}
+
+// gorm 租约的读写都走主库，从库有复制延迟，刚写入的 owner 和 fence 可能还读不到
+func (m *MySqlLeaseLock) gorm() *gorm.DB {
+	return m.db.MainDB().WithContext(dbs.WithPrimary(context.Background()))
+}
+
+// CreateTable 创建租约表
+func (m *MySqlLeaseLock) CreateTable() error {
+	return m.gorm().Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ("+
+		"`lock_key` varchar(191) NOT NULL,"+
+		"`owner` varchar(64) NOT NULL,"+
+		"`fence` bigint NOT NULL DEFAULT 0,"+
+		"`expires_at` datetime(3) NOT NULL,"+
+		"PRIMARY KEY (`lock_key`)"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4", m.table)).Error
+}
+
+// tryAcquire 行不存在或租约已过期时写入新的 owner 并递增 fence。
+// ON DUPLICATE KEY UPDATE 按顺序赋值，expires_at 必须放在最后，前面的判断才能读到旧值
+func (m *MySqlLeaseLock) tryAcquire(key, owner string, timeout time.Duration) (int64, bool, error) {
+	db := m.gorm()
+	err := db.Exec(fmt.Sprintf("INSERT INTO `%s` (lock_key, owner, fence, expires_at) "+
+		"VALUES (?, ?, 1, DATE_ADD(NOW(3), INTERVAL ? MICROSECOND)) "+
+		"ON DUPLICATE KEY UPDATE "+
+		"fence = IF(expires_at < NOW(3), fence + 1, fence), "+
+		"owner = IF(expires_at < NOW(3), VALUES(owner), owner), "+
+		"expires_at = IF(owner = VALUES(owner), VALUES(expires_at), expires_at)", m.table),
+		key, owner, timeout.Microseconds()).Error
+	if err != nil {
+		return 0, false, err
+	}
+	var lease LockLease
+	err = db.Table(m.table).Where("lock_key = ? AND owner = ?", key, owner).Take(&lease).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return lease.Fence, true, nil
+}
+
+func (m *MySqlLeaseLock) Lock(key string, timeout time.Duration) (string, error) {
+	val, _, err := m.LockWithFence(key, timeout)
+	return val, err
+}
+
+// LockWithFence 加锁并返回该 key 单调递增的 fencing token
+func (m *MySqlLeaseLock) LockWithFence(key string, timeout time.Duration) (string, int64, error) {
+	val := uuids.UUID()
+	for {
+		fence, ok, err := m.tryAcquire(key, val, timeout)
+		if err != nil {
+			return val, 0, err
+		}
+		if ok {
+			return val, fence, nil
+		}
+		time.Sleep(m.retryTick)
+	}
+}
+
+// UnLock 只有 owner 与 val 一致时才会释放。释放只是让租约立即过期，保留行以保证 fence 单调递增
+func (m *MySqlLeaseLock) UnLock(key string, val string) error {
+	return m.gorm().Exec(fmt.Sprintf("UPDATE `%s` SET expires_at = NOW(3) - INTERVAL 1 MICROSECOND "+
+		"WHERE lock_key = ? AND owner = ?", m.table), key, val).Error
+}
+
+// Renew 续期，租约已过期或被他人持有时返回 ErrLockLost
+func (m *MySqlLeaseLock) Renew(key string, val string, timeout time.Duration) error {
+	res := m.gorm().Exec(fmt.Sprintf("UPDATE `%s` SET expires_at = DATE_ADD(NOW(3), INTERVAL ? MICROSECOND) "+
+		"WHERE lock_key = ? AND owner = ? AND expires_at >= NOW(3)", m.table), timeout.Microseconds(), key, val)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	// 没有开启 clientFoundRows 时，新旧 expires_at 相同也会返回 0，需要再查一次租约是否仍然有效
+	var n int64
+	err := m.gorm().Table(m.table).Where("lock_key = ? AND owner = ? AND expires_at >= NOW(3)", key, val).Count(&n).Error
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (m *MySqlLeaseLock) Intercept(key string, timeout time.Duration) error {
+	_, ok, err := m.tryAcquire("Intercept:"+key, uuids.UUID(), timeout)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrToManyTimes
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package locks
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs"
+	"github.com/ville-vv/gutils/dbs/sqltest"
+	"gorm.io/gorm"
+)
+
+// fakeMySqlDB 只实现 MainDB
+type fakeMySqlDB struct {
+	dbs.IMySqlDB
+	db *gorm.DB
+}
+
+func (f *fakeMySqlDB) MainDB() *gorm.DB { return f.db }
+
+func newFakeMySqlDB(t *testing.T, handle sqltest.Handler) (*fakeMySqlDB, *sqltest.DB) {
+	f := sqltest.Open(t, handle)
+	return &fakeMySqlDB{db: f.Gorm(t)}, f
+}
+
+func TestMySqlLock_SQL(t *testing.T) {
+	db, f := newFakeMySqlDB(t, func(query string, args []driver.NamedValue) sqltest.Result {
+		return sqltest.Result{Columns: []string{"res"}, Rows: [][]driver.Value{{int64(1)}}}
+	})
+	lc := NewMySqlLock(db)
+
+	val, err := lc.Lock("Order0001", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, lc.UnLock("Order0001", val))
+	assert.Equal(t, []string{"SELECT GET_LOCK(?, 1)", "SELECT RELEASE_LOCK(?)"}, f.Queries())
+
+	// 超过 64 个字符的 key 使用摘要作为锁名
+	name := mysqlLockName(strings.Repeat("k", 100))
+	assert.LessOrEqual(t, len(name), mysqlLockNameMaxLen)
+	assert.Equal(t, "Locks:Order0001", mysqlLockName("Order0001"))
+}
+
+func TestMySqlLeaseLock_SQL(t *testing.T) {
+	now := time.Now()
+	db, f := newFakeMySqlDB(t, func(query string, args []driver.NamedValue) sqltest.Result {
+		switch {
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			return sqltest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{int64(1)}}}
+		case strings.HasPrefix(query, "SELECT"):
+			return sqltest.Result{Columns: []string{"lock_key", "owner", "fence", "expires_at"},
+				Rows: [][]driver.Value{{args[0].Value, args[1].Value, int64(3), now}}}
+		}
+		return sqltest.Result{RowsAffected: 1}
+	})
+	lc := NewMySqlLeaseLock(db, "leases")
+
+	val, fence, err := lc.LockWithFence("Order0001", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), fence)
+	require.NoError(t, lc.UnLock("Order0001", val))
+
+	queries := f.Queries()
+	require.Len(t, queries, 3)
+	assert.True(t, strings.HasPrefix(queries[0], "INSERT INTO `leases`"))
+	assert.Contains(t, queries[0], "ON DUPLICATE KEY UPDATE")
+	// expires_at 必须最后赋值
+	assert.True(t, strings.HasSuffix(queries[0], "expires_at = IF(owner = VALUES(owner), VALUES(expires_at), expires_at)"))
+	assert.Contains(t, queries[1], "FROM `leases` WHERE lock_key = ? AND owner = ?")
+	assert.True(t, strings.HasPrefix(queries[2], "UPDATE `leases` SET expires_at = NOW(3)"))
+}
+
+func TestMySqlLeaseLock_Renew(t *testing.T) {
+	var affected, valid int64
+	db, f := newFakeMySqlDB(t, func(query string, args []driver.NamedValue) sqltest.Result {
+		if strings.HasPrefix(query, "SELECT count(*)") {
+			return sqltest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{valid}}}
+		}
+		return sqltest.Result{RowsAffected: affected}
+	})
+	lc := NewMySqlLeaseLock(db)
+
+	affected = 1
+	assert.NoError(t, lc.Renew("Order0001", "a", time.Second))
+	assert.Len(t, f.Queries(), 1)
+
+	// 新旧 expires_at 相同时 RowsAffected 为 0，但租约仍然有效
+	affected, valid = 0, 1
+	assert.NoError(t, lc.Renew("Order0001", "a", time.Second))
+	queries := f.Queries()
+	require.Len(t, queries, 3)
+	assert.Contains(t, queries[2], "WHERE lock_key = ? AND owner = ? AND expires_at >= NOW(3)")
+
+	affected, valid = 0, 0
+	assert.ErrorIs(t, lc.Renew("Order0001", "a", time.Second), ErrLockLost)
+}
+
+func TestMySqlLeaseLock_Replica(t *testing.T) {
+	now := time.Now()
+	db, primary := newFakeMySqlDB(t, func(query string, args []driver.NamedValue) sqltest.Result {
+		switch {
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			return sqltest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{int64(1)}}}
+		case strings.HasPrefix(query, "SELECT"):
+			return sqltest.Result{Columns: []string{"lock_key", "owner", "fence", "expires_at"},
+				Rows: [][]driver.Value{{args[0].Value, args[1].Value, int64(3), now}}}
+		}
+		return sqltest.Result{}
+	})
+	// 从库还没有复制到刚写入的租约
+	replica := sqltest.Open(t, func(query string, args []driver.NamedValue) sqltest.Result {
+		return sqltest.Result{Err: errors.New("read from replica")}
+	})
+	plugin := dbs.NewReplicaPlugin("main", dbs.ReplicaConfig{}, replica.DB)
+	t.Cleanup(plugin.Close)
+	require.NoError(t, db.db.Use(plugin))
+	// MainDB 返回的会话默认允许读从库
+	db.db = db.db.WithContext(dbs.WithReplica(context.Background()))
+
+	lc := NewMySqlLeaseLock(db)
+	_, fence, err := lc.LockWithFence("Order0001", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), fence)
+	assert.NoError(t, lc.Renew("Order0001", "a", time.Second))
+	assert.Empty(t, replica.Queries())
+	assert.Len(t, primary.Queries(), 4)
+}
@@ -27,20 +27,72 @@ func (r *RedisLock) interceptKey(k string) string {
 	return fmt.Sprintf("Intercept:%s", k)
 }
 
+func (r *RedisLock) fenceKey(k string) string {
+	return fmt.Sprintf("Fence:%s", k)
+}
+
+// 抢锁成功时在同一个脚本内递增 fencing token，保证 token 与加锁原子绑定
+var lockWithFenceScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
 func (r *RedisLock) Lock(key string, timeout time.Duration) (string, error) {
+	val, _, err := r.LockWithFence(key, timeout)
+	return val, err
+}
+
+// LockWithFence 加锁并返回该 key 单调递增的 fencing token，
+// 写存储时带上 token，存储层即可拒绝已过期持有者的写入
+func (r *RedisLock) LockWithFence(key string, timeout time.Duration) (string, int64, error) {
 	val := fmt.Sprintf("%d", time.Now().UnixNano())
 	for {
-		res, err := r.rds.SetNX(context.Background(), r.lockKey(key), val, timeout).Result()
+		fence, ok, err := r.tryLockWithFence(context.Background(), key, val, timeout)
 		if err != nil {
-			return val, err
+			return val, 0, err
 		}
-		if res {
-			return val, nil
+		if ok {
+			return val, fence, nil
 		}
 		time.Sleep(time.Millisecond * 100)
 	}
 }
 
+func (r *RedisLock) tryLockWithFence(ctx context.Context, key, val string, timeout time.Duration) (int64, bool, error) {
+	keys := []string{r.lockKey(key), r.fenceKey(key)}
+	fence, err := lockWithFenceScript.Run(ctx, r.rds, keys, val, timeout.Milliseconds()).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	return fence, fence > 0, nil
+}
+
+var renewLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+else
+	return 0
+end
+`)
+
+// Renew 续期，只有 val 与当前持有者一致时才会成功，否则返回 ErrLockLost
+func (r *RedisLock) Renew(key string, val string, timeout time.Duration) error {
+	return r.renew(context.Background(), key, val, timeout)
+}
+
+func (r *RedisLock) renew(ctx context.Context, key string, val string, timeout time.Duration) error {
+	res, err := renewLockScript.Run(ctx, r.rds, []string{r.lockKey(key)}, val, timeout.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
 func (r *RedisLock) UnLock(key string, val string) error {
 	key = r.lockKey(key)
 	script := `
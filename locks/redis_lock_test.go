@@ -1,15 +1,18 @@
 package locks
 
 import (
-	"github.com/go-redis/redis"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/ville-vv/gutils/dbs/redistest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestRedisLock_Lock(t *testing.T) {
 	rds := redis.NewClient(&redis.Options{
-		Addr: "127.0.0.1:6379",
+		Addr: redistest.Run(t).Addr(),
 	})
 	lc := NewRedisLock(rds)
 	key := "Order0001"
@@ -18,53 +21,97 @@ func TestRedisLock_Lock(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		sum += i
 	}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
 	for i := 0; i < 100; i++ {
+		wg.Add(1)
 		go func(a int) {
+			defer wg.Done()
 			unlockFlow, err := lc.Lock(key, time.Second*1)
 			if err != nil {
 				assert.NoError(t, err)
 				return
 			}
+			mu.Lock()
 			sumCh += a
+			mu.Unlock()
 			_ = lc.UnLock(key, unlockFlow)
 		}(i)
 	}
-	time.Sleep(3 * time.Second)
+	wg.Wait()
 	assert.Equal(t, sum, sumCh)
 }
 
 func TestRedisLock_Lock02(t *testing.T) {
+	srv := redistest.Run(t)
 	rds := redis.NewClient(&redis.Options{
-		Addr: "127.0.0.1:6379",
+		Addr: srv.Addr(),
 	})
 	lc := NewRedisLock(rds)
 	key := "Order0001"
-	sum := 0
-	sumCh := 0
-	for i := 0; i < 100; i++ {
-		sum += i
-	}
-	for i := 0; i < 100; i++ {
-		go func(a int) {
+	var (
+		acquired int32
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 			_, err := lc.Lock(key, time.Second*1)
-			//fmt.Println("获得锁", a)
-			if err != nil {
-				assert.NoError(t, err)
-				return
-			}
-			sumCh += a
-			//_ = lc.UnLock(lockKey, unlockFlow)
-		}(i)
+			assert.NoError(t, err)
+			atomic.AddInt32(&acquired, 1)
+		}()
+	}
+	// 不释放时锁一直被第一个获得者持有
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&acquired))
+
+	// 服务端时钟前进让锁过期，剩下的等待者依次获得锁
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, int32(10), atomic.LoadInt32(&acquired))
+			return
+		case <-time.After(50 * time.Millisecond):
+			srv.FastForward(time.Second)
+		}
 	}
-	time.Sleep(6 * time.Second)
-	assert.NotEqual(t, sum, sumCh)
+}
+
+func TestRedisLock_LockWithFence(t *testing.T) {
+	srv := redistest.Run(t)
+	lc := NewRedisLock(srv.Client())
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		val, fence, err := lc.LockWithFence("Order0003", time.Second)
+		assert.NoError(t, err)
+		assert.Greater(t, fence, last)
+		last = fence
+		assert.NoError(t, lc.UnLock("Order0003", val))
+	}
+
+	// 锁过期后被新的持有者接管，token 继续递增
+	_, fence, err := lc.LockWithFence("Order0003", time.Second)
+	assert.NoError(t, err)
+	srv.FastForward(2 * time.Second)
+	_, next, err := lc.LockWithFence("Order0003", time.Second)
+	assert.NoError(t, err)
+	assert.Greater(t, next, fence)
 }
 
 func BenchmarkRedisLock_Lock(b *testing.B) {
 	b.Skip()
 	b.StopTimer()
 	rds := redis.NewClient(&redis.Options{
-		Addr: "127.0.0.1:6379",
+		Addr: redistest.Run(b).Addr(),
 	})
 	lc := NewRedisLock(rds)
 	key := "Order0001"
@@ -0,0 +1,246 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ville-vv/gutils/uuids"
+)
+
+var (
+	ErrSemaphoreLost = errors.New("semaphore permit lost")
+)
+
+// 所有脚本都使用 redis 服务器时间，避免各节点时钟不一致导致误判过期。
+// extend 只延长 key 的过期时间，避免 ttl 较短的调用方把其他持有者一起过期掉
+const semaphoreNowScript = `
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local function extend(key, ms)
+	if redis.call("PTTL", key) < tonumber(ms) then
+		redis.call("PEXPIRE", key, ms)
+	end
+end
+`
+
+// KEYS[1] 持有者 ZSET(member=holder, score=过期时间ms)
+// ARGV[1] 许可数量 ARGV[2] holder ARGV[3] ttl(ms)
+var semaphoreAcquireScript = redis.NewScript(semaphoreNowScript + `
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[2])
+	extend(KEYS[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// KEYS[1] 持有者 ZSET KEYS[2] 等待队列 ZSET(score=排队号) KEYS[3] 等待者心跳 ZSET(score=过期时间ms) KEYS[4] 排队号计数器
+// ARGV[1] 许可数量 ARGV[2] holder ARGV[3] ttl(ms) ARGV[4] 等待者心跳超时(ms)
+// 只有排在队首的等待者才能拿到许可，保证 FIFO
+var semaphoreFairAcquireScript = redis.NewScript(semaphoreNowScript + `
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local stale = redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", now)
+for i = 1, #stale do
+	redis.call("ZREM", KEYS[2], stale[i])
+	redis.call("ZREM", KEYS[3], stale[i])
+end
+if redis.call("ZSCORE", KEYS[2], ARGV[2]) == false then
+	redis.call("ZADD", KEYS[2], redis.call("INCR", KEYS[4]), ARGV[2])
+end
+redis.call("ZADD", KEYS[3], now + tonumber(ARGV[4]), ARGV[2])
+local keep = math.max(tonumber(ARGV[3]), tonumber(ARGV[4]))
+extend(KEYS[2], keep)
+extend(KEYS[3], keep)
+extend(KEYS[4], keep)
+if redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[1]) and redis.call("ZRANK", KEYS[2], ARGV[2]) == 0 then
+	redis.call("ZREM", KEYS[2], ARGV[2])
+	redis.call("ZREM", KEYS[3], ARGV[2])
+	redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[2])
+	extend(KEYS[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// KEYS[1] 持有者 ZSET ARGV[1] holder ARGV[2] ttl(ms)
+var semaphoreRenewScript = redis.NewScript(semaphoreNowScript + `
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score == false or tonumber(score) <= now then
+	redis.call("ZREM", KEYS[1], ARGV[1])
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+extend(KEYS[1], ARGV[2])
+return 1
+`)
+
+// KEYS[1] 持有者 ZSET
+var semaphoreCountScript = redis.NewScript(semaphoreNowScript + `
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+return redis.call("ZCARD", KEYS[1])
+`)
+
+type SemaphoreOption func(*Semaphore)
+
+// WithSemaphoreFair 公平模式，许可按排队顺序发放
+func WithSemaphoreFair(fair bool) SemaphoreOption {
+	return func(s *Semaphore) {
+		s.fair = fair
+	}
+}
+
+// WithSemaphoreRetryTick 获取许可失败后的重试间隔
+func WithSemaphoreRetryTick(d time.Duration) SemaphoreOption {
+	return func(s *Semaphore) {
+		s.retryTick = d
+	}
+}
+
+// WithSemaphoreWaitTimeout 公平模式下等待者的心跳超时，超时未重试的等待者会被移出队列
+func WithSemaphoreWaitTimeout(d time.Duration) SemaphoreOption {
+	return func(s *Semaphore) {
+		s.waitTimeout = d
+	}
+}
+
+// Semaphore 基于 redis 的分布式计数信号量，持有者以 ZSET 保存，score 为租约过期时间
+type Semaphore struct {
+	rds         redis.Cmdable
+	fair        bool
+	retryTick   time.Duration
+	waitTimeout time.Duration
+}
+
+func NewSemaphore(rds redis.Cmdable, opts ...SemaphoreOption) *Semaphore {
+	s := &Semaphore{
+		rds:         rds,
+		retryTick:   time.Millisecond * 100,
+		waitTimeout: time.Second * 3,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.waitTimeout < s.retryTick*3 {
+		s.waitTimeout = s.retryTick * 3
+	}
+	return s
+}
+
+func (s *Semaphore) holdersKey(k string) string {
+	return "Semaphore:{" + k + "}:holders"
+}
+
+func (s *Semaphore) queueKey(k string) string {
+	return "Semaphore:{" + k + "}:queue"
+}
+
+func (s *Semaphore) waitKey(k string) string {
+	return "Semaphore:{" + k + "}:wait"
+}
+
+func (s *Semaphore) counterKey(k string) string {
+	return "Semaphore:{" + k + "}:counter"
+}
+
+// Acquire 获取一个许可，最多允许 n 个持有者同时存在，阻塞直到成功或 ctx 结束。
+// 返回的 holder 用于 Release 和 Renew
+func (s *Semaphore) Acquire(ctx context.Context, key string, n int64, ttl time.Duration) (string, error) {
+	holder := uuids.UUID()
+	ticker := time.NewTicker(s.retryTick)
+	defer ticker.Stop()
+	for {
+		ok, err := s.tryAcquire(ctx, key, holder, n, ttl)
+		if err != nil {
+			s.leaveQueue(key, holder)
+			return "", err
+		}
+		if ok {
+			return holder, nil
+		}
+		select {
+		case <-ctx.Done():
+			s.leaveQueue(key, holder)
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// TryAcquire 尝试获取一个许可，不阻塞。公平模式下只有没有其他等待者时才能成功
+func (s *Semaphore) TryAcquire(ctx context.Context, key string, n int64, ttl time.Duration) (string, bool, error) {
+	holder := uuids.UUID()
+	ok, err := s.tryAcquire(ctx, key, holder, n, ttl)
+	if !ok {
+		s.leaveQueue(key, holder)
+		return "", false, err
+	}
+	return holder, true, nil
+}
+
+func (s *Semaphore) tryAcquire(ctx context.Context, key, holder string, n int64, ttl time.Duration) (bool, error) {
+	var (
+		res int64
+		err error
+	)
+	if s.fair {
+		keys := []string{s.holdersKey(key), s.queueKey(key), s.waitKey(key), s.counterKey(key)}
+		res, err = semaphoreFairAcquireScript.Run(ctx, s.rds, keys, n, holder, ttl.Milliseconds(), s.waitTimeout.Milliseconds()).Int64()
+	} else {
+		res, err = semaphoreAcquireScript.Run(ctx, s.rds, []string{s.holdersKey(key)}, n, holder, ttl.Milliseconds()).Int64()
+	}
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// leaveQueue 放弃等待时退出队列，避免阻塞后面的等待者
+func (s *Semaphore) leaveQueue(key, holder string) {
+	if !s.fair {
+		return
+	}
+	ctx := context.Background()
+	_, _ = s.rds.ZRem(ctx, s.queueKey(key), holder).Result()
+	_, _ = s.rds.ZRem(ctx, s.waitKey(key), holder).Result()
+}
+
+// Release 释放许可
+func (s *Semaphore) Release(ctx context.Context, key string, holder string) error {
+	return s.rds.ZRem(ctx, s.holdersKey(key), holder).Err()
+}
+
+// Renew 续约，许可已过期或被清理时返回 ErrSemaphoreLost
+func (s *Semaphore) Renew(ctx context.Context, key string, holder string, ttl time.Duration) error {
+	res, err := semaphoreRenewScript.Run(ctx, s.rds, []string{s.holdersKey(key)}, holder, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrSemaphoreLost
+	}
+	return nil
+}
+
+// KeepAlive 每 ttl/3 续约一次，直到 ctx 结束或许可丢失
+func (s *Semaphore) KeepAlive(ctx context.Context, key string, holder string, ttl time.Duration) error {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := s.Renew(ctx, key, holder, ttl); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Count 当前有效的持有者数量，同时清理过期的持有者
+func (s *Semaphore) Count(ctx context.Context, key string) (int64, error) {
+	return semaphoreCountScript.Run(ctx, s.rds, []string{s.holdersKey(key)}).Int64()
+}
@@ -0,0 +1,149 @@
+package locks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ville-vv/gutils/dbs/redistest"
+)
+
+func TestSemaphore_TryAcquire(t *testing.T) {
+	rds := redistest.Run(t).Client()
+	ctx := context.Background()
+
+	for _, fair := range []bool{false, true} {
+		sem := NewSemaphore(rds, WithSemaphoreFair(fair))
+		key := "res"
+		if fair {
+			key = "res-fair"
+		}
+		h1, ok, err := sem.TryAcquire(ctx, key, 2, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, ok, err = sem.TryAcquire(ctx, key, 2, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, ok, err = sem.TryAcquire(ctx, key, 2, time.Minute)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		n, err := sem.Count(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+
+		require.NoError(t, sem.Renew(ctx, key, h1, time.Minute))
+		require.NoError(t, sem.Release(ctx, key, h1))
+		assert.ErrorIs(t, sem.Renew(ctx, key, h1, time.Minute), ErrSemaphoreLost)
+
+		_, ok, err = sem.TryAcquire(ctx, key, 2, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+}
+
+func TestSemaphore_AcquireBlocks(t *testing.T) {
+	rds := redistest.Run(t).Client()
+	sem := NewSemaphore(rds, WithSemaphoreRetryTick(10*time.Millisecond))
+	ctx := context.Background()
+
+	h1, err := sem.Acquire(ctx, "res", 1, time.Minute)
+	require.NoError(t, err)
+
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = sem.Acquire(short, "res", 1, time.Minute)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	got := make(chan string, 1)
+	go func() {
+		h, err := sem.Acquire(ctx, "res", 1, time.Minute)
+		assert.NoError(t, err)
+		got <- h
+	}()
+	select {
+	case <-got:
+		t.Fatal("acquired while the permit is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, sem.Release(ctx, "res", h1))
+	select {
+	case h := <-got:
+		assert.NotEmpty(t, h)
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after release")
+	}
+}
+
+func TestSemaphore_FairFIFO(t *testing.T) {
+	rds := redistest.Run(t).Client()
+	sem := NewSemaphore(rds, WithSemaphoreFair(true), WithSemaphoreRetryTick(10*time.Millisecond))
+	ctx := context.Background()
+
+	h0, err := sem.Acquire(ctx, "res", 1, time.Minute)
+	require.NoError(t, err)
+
+	type result struct {
+		name   string
+		holder string
+	}
+	order := make(chan result, 2)
+	waitQueued := func(n int64) {
+		require.Eventually(t, func() bool {
+			return rds.ZCard(ctx, sem.queueKey("res")).Val() == n
+		}, time.Second, 5*time.Millisecond)
+	}
+	for i, name := range []string{"first", "second"} {
+		go func(name string) {
+			h, err := sem.Acquire(ctx, "res", 1, time.Minute)
+			assert.NoError(t, err)
+			order <- result{name, h}
+		}(name)
+		waitQueued(int64(i + 1))
+	}
+
+	require.NoError(t, sem.Release(ctx, "res", h0))
+	r := <-order
+	assert.Equal(t, "first", r.name)
+	select {
+	case <-order:
+		t.Fatal("second waiter acquired while first holds the permit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, sem.Release(ctx, "res", r.holder))
+	r = <-order
+	assert.Equal(t, "second", r.name)
+}
+
+func TestSemaphore_MixedTTL(t *testing.T) {
+	srv := redistest.Run(t)
+	rds := srv.Client()
+	ctx := context.Background()
+	now := time.Now()
+	srv.SetTime(now)
+
+	for _, fair := range []bool{false, true} {
+		sem := NewSemaphore(rds, WithSemaphoreFair(fair))
+		key := "res"
+		if fair {
+			key = "res-fair"
+		}
+		long, ok, err := sem.TryAcquire(ctx, key, 2, time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, ok, err = sem.TryAcquire(ctx, key, 2, 100*time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// 短租约过期不能把长租约的持有者一起删掉
+		now = now.Add(time.Second)
+		srv.SetTime(now)
+		srv.FastForward(time.Second)
+		n, err := sem.Count(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+		assert.NoError(t, sem.Renew(ctx, key, long, time.Minute))
+	}
+}
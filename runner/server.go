@@ -26,7 +26,13 @@ func NewServerGroup() *ServerGroup {
 	return sg
 }
 
+// Add 添加服务，需要在 Start 之前调用
+func (sel *ServerGroup) Add(svr ...Server) {
+	sel.servers = append(sel.servers, svr...)
+}
+
 func (sel *ServerGroup) Start() {
+	sel.start()
 	// 创建一个信号通道
 	signalChan := make(chan os.Signal, 1)
 	// 监控系统信号，如中断（Ctrl+C）和终止信号
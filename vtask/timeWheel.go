@@ -105,7 +105,7 @@ type TimeWheel struct {
 	taskLen    int64         // 任务数量
 	slotNum    int           // 槽位数量
 	slots      []*Slot       // 时间槽链表
-	cursor     int           // 当前槽指针
+	cursor     atomic.Int64  // 当前槽指针，只由 advance 写入
 	ticker     *time.Ticker  // 时间驱动器
 	taskMap    sync.Map      // 任务存储 map[TaskID]*list.Element
 	idSequence atomic.Uint64 // 原子ID生成器
@@ -135,7 +135,6 @@ func NewTimeWheel(opts ...TimeWheelOption) *TimeWheel {
 		interval: interval,
 		slotNum:  slotsNum,
 		slots:    slots,
-		cursor:   0,
 		stopCh:   make(chan struct{}),
 	}
 
@@ -174,7 +173,7 @@ func (tw *TimeWheel) slotFromID(id TaskID) int {
 func (tw *TimeWheel) AddTask(delay time.Duration, handler TaskHandler, param interface{}) TaskID {
 	// 计算目标槽位
 	steps := int(delay / tw.interval)
-	slotIdx := (tw.cursor + steps) % tw.slotNum
+	slotIdx := (int(tw.cursor.Load()) + steps) % tw.slotNum
 	// 生成唯一ID
 	id := tw.generateID(slotIdx)
 	entry := &taskEntry{
@@ -193,8 +192,9 @@ func (tw *TimeWheel) AddTask(delay time.Duration, handler TaskHandler, param int
 
 func (tw *TimeWheel) advance() {
 	// 1. 快速获取任务快照
-	tasks := tw.slots[tw.cursor].prepareTasks()
-	tw.cursor = (tw.cursor + 1) % tw.slotNum
+	cursor := tw.cursor.Load()
+	tasks := tw.slots[cursor].prepareTasks()
+	tw.cursor.Store((cursor + 1) % int64(tw.slotNum))
 	// 2. 并行处理任务
 	tw.processTasks(tasks)
 }
@@ -206,6 +206,7 @@ func (tw *TimeWheel) processTasks(tasks []*taskEntry) {
 	}
 	for _, task := range tasks {
 		atomic.AddInt64(&tw.taskLen, -1)
+		tw.taskMap.Delete(task.id)
 		_ = tw.workPool.Submit(func() {
 			tw.safeExecute(task)
 		})